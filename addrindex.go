@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urfave/cli"
+)
+
+// The address index is a separate leveldb with these key prefixes, heights
+// are big endian so that entries sort by height:
+//
+//	a + programhash(20) + height(4) + txid(32) + direction(1) + assetid(32) -> amount(8)
+//	b + height(4) -> block hash, for every indexed block
+//	r + height(4) + a key -> empty, to undo a height on reorg
+//	t -> height(4) of the last indexed block
+const (
+	addrEntryPrefix   = 'a'
+	addrBlockPrefix   = 'b'
+	addrReversePrefix = 'r'
+	addrTipKey        = 't'
+
+	addrDirectionIn  = 0
+	addrDirectionOut = 1
+
+	addrIndexBatchBlocks = 1000
+)
+
+type addrEntry struct {
+	height    uint32
+	txid      common.Uint256
+	direction byte
+	assetID   common.Uint256
+	amount    common.Fixed64
+}
+
+func NewAddrIndexCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "addr-index",
+		Usage:       "maintain the address transaction index",
+		Description: "maintain a side leveldb indexing the transactions of every program hash, the node db is only read",
+		Subcommands: []cli.Command{
+			{
+				Name:        "build",
+				Usage:       "index the blocks added since the last build",
+				Description: "scan the blocks above the last indexed height into the index, blocks replaced by a reorg are removed first",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "index",
+						Usage: "the address index directory",
+						Value: "./addrindex",
+					},
+				},
+				Action: addrIndexBuildAction,
+				OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+					return cli.NewExitError("", 1)
+				},
+			},
+		},
+	}
+}
+
+func NewAddrHistoryCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "addr-history",
+		Usage:       "list the transactions of an address from the address index",
+		Description: "list the indexed transactions of an address or program hash with direction, amount, asset and height, run addr-index build first",
+		ArgsUsage:   "<address|programhash>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "index",
+				Usage: "the address index directory",
+				Value: "./addrindex",
+			},
+		},
+		Action: addrHistoryAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func addrIndexBuildAction(c *cli.Context) error {
+	path := c.GlobalString("path")
+	indexPath := c.String("index")
+	if same, err := samePath(path, indexPath); err != nil {
+		return err
+	} else if same {
+		return cli.NewExitError("the address index can not be written into the node db", 1)
+	}
+
+	st, err := openStore(path)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	idx, err := leveldb.OpenFile(indexPath, nil)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	next, err := addrIndexUnwind(st, idx)
+	if err != nil {
+		return err
+	}
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+	if next > current {
+		fmt.Printf("address index is up to date at height %d\n", current)
+		return nil
+	}
+
+	unresolved := 0
+	p := newProgress(int(current-next)+1, current, 5*time.Second)
+	for height := next; height <= current; height += addrIndexBatchBlocks {
+		end := height + addrIndexBatchBlocks - 1
+		if end > current {
+			end = current
+		}
+
+		batch := new(leveldb.Batch)
+		for h := height; h <= end; h++ {
+			n, err := addrIndexBlock(st, batch, h)
+			if err != nil {
+				return fmt.Errorf("index block %d: %v", h, err)
+			}
+			unresolved += n
+			p.update(h)
+		}
+		batch.Put([]byte{addrTipKey}, bigEndianHeight(end))
+		if err := idx.Write(batch, nil); err != nil {
+			return err
+		}
+	}
+
+	if unresolved > 0 {
+		fmt.Printf("%d inputs refer to pruned transactions and are missing from the index\n", unresolved)
+	}
+	fmt.Printf("indexed blocks %d to %d\n", next, current)
+
+	return nil
+}
+
+func addrHistoryAction(c *cli.Context) error {
+	if c.NArg() < 1 {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	programHash, err := parseProgramHash(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	indexPath := c.String("index")
+	if exist, err := PathExists(indexPath); err != nil {
+		return err
+	} else if !exist {
+		return cli.NewExitError("address index "+indexPath+" does not exist, run addr-index build first", 1)
+	}
+
+	idx, err := leveldb.OpenFile(indexPath, &opt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	entries, err := getAddrHistory(idx, programHash)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		direction := "in"
+		if e.direction == addrDirectionOut {
+			direction = "out"
+		}
+		fmt.Printf("height:%d, tx:%s, direction:%s, asset:%s, amount:%s\n",
+			e.height, hashString(e.txid), direction, hashString(e.assetID), e.amount.String())
+	}
+
+	tip, err := idx.Get([]byte{addrTipKey}, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d entries, indexed up to height %d\n", len(entries), binary.BigEndian.Uint32(tip))
+
+	return nil
+}
+
+func samePath(a string, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+
+	return absA == absB, nil
+}
+
+func bigEndianHeight(height uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, height)
+	return buf
+}
+
+// addrIndexUnwind removes the indexed blocks that are no longer on the main
+// chain and returns the next height to index.
+func addrIndexUnwind(st store, idx *leveldb.DB) (uint32, error) {
+	value, err := idx.Get([]byte{addrTipKey}, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	tip := binary.BigEndian.Uint32(value)
+
+	fork := int64(tip)
+	for ; fork >= 0; fork-- {
+		indexed, err := idx.Get(append([]byte{addrBlockPrefix}, bigEndianHeight(uint32(fork))...), nil)
+		if err != nil {
+			return 0, err
+		}
+		if hash, err := getBlockHash(st, uint32(fork)); err == nil && bytes.Equal(hash.ToArray(), indexed) {
+			break
+		}
+	}
+	if fork == int64(tip) {
+		return tip + 1, nil
+	}
+
+	batch := new(leveldb.Batch)
+	for h := fork + 1; h <= int64(tip); h++ {
+		height := bigEndianHeight(uint32(h))
+		iter := idx.NewIterator(util.BytesPrefix(append([]byte{addrReversePrefix}, height...)), nil)
+		for iter.Next() {
+			batch.Delete(append([]byte{}, iter.Key()[5:]...))
+			batch.Delete(append([]byte{}, iter.Key()...))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return 0, err
+		}
+		batch.Delete(append([]byte{addrBlockPrefix}, height...))
+	}
+
+	if fork < 0 {
+		batch.Delete([]byte{addrTipKey})
+	} else {
+		batch.Put([]byte{addrTipKey}, bigEndianHeight(uint32(fork)))
+	}
+	if err := idx.Write(batch, nil); err != nil {
+		return 0, err
+	}
+	fmt.Printf("reorg: removed indexed blocks %d to %d\n", fork+1, tip)
+
+	return uint32(fork + 1), nil
+}
+
+// addrIndexBlock adds the entries of the block at height to batch, amounts
+// are summed per transaction, program hash, direction and asset. It returns
+// the number of inputs whose referred transaction is missing.
+func addrIndexBlock(st store, batch *leveldb.Batch, height uint32) (int, error) {
+	b, err := getBlockByHeight(st, height)
+	if err != nil {
+		return 0, err
+	}
+	hash := b.Hash()
+	batch.Put(append([]byte{addrBlockPrefix}, bigEndianHeight(height)...), hash.ToArray())
+
+	unresolved := 0
+	for _, txn := range b.Transactions {
+		txid := txn.Hash()
+		amounts := make(map[string]common.Fixed64)
+		add := func(programHash common.Uint160, direction byte, assetID common.Uint256, value common.Fixed64) {
+			key := bytes.NewBuffer(nil)
+			key.WriteByte(addrEntryPrefix)
+			key.Write(programHash.ToArray())
+			key.Write(bigEndianHeight(height))
+			key.Write(txid.ToArray())
+			key.WriteByte(direction)
+			key.Write(assetID.ToArray())
+			amounts[key.String()] += value
+		}
+
+		for _, output := range txn.Outputs {
+			add(output.ProgramHash, addrDirectionIn, output.AssetID, output.Value)
+		}
+		for _, input := range txn.Inputs {
+			refer, _, err := getTransaction(st, input.ReferTxID)
+			if err != nil || int(input.ReferTxOutputIndex) >= len(refer.Outputs) {
+				unresolved++
+				continue
+			}
+			output := refer.Outputs[input.ReferTxOutputIndex]
+			add(output.ProgramHash, addrDirectionOut, output.AssetID, output.Value)
+		}
+
+		for key, amount := range amounts {
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(amount))
+			batch.Put([]byte(key), value)
+			batch.Put(append(append([]byte{addrReversePrefix}, bigEndianHeight(height)...), key...), nil)
+		}
+	}
+
+	return unresolved, nil
+}
+
+// getAddrHistory reads the entries of programHash ordered by height.
+func getAddrHistory(idx *leveldb.DB, programHash common.Uint160) ([]*addrEntry, error) {
+	entries := make([]*addrEntry, 0)
+	iter := idx.NewIterator(util.BytesPrefix(append([]byte{addrEntryPrefix}, programHash.ToArray()...)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 90 || len(iter.Value()) != 8 {
+			return nil, errors.New("invalid address index entry " + hex.EncodeToString(key))
+		}
+
+		txid, err := common.Uint256ParseFromBytes(key[25:57])
+		if err != nil {
+			return nil, err
+		}
+		assetID, err := common.Uint256ParseFromBytes(key[58:90])
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &addrEntry{
+			height:    binary.BigEndian.Uint32(key[21:25]),
+			txid:      txid,
+			direction: key[57],
+			assetID:   assetID,
+			amount:    common.Fixed64(binary.BigEndian.Uint64(iter.Value())),
+		})
+	}
+
+	return entries, iter.Error()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/common/serialization"
+	"github.com/nknorg/nkn/core/asset"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/core/transaction/payload"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+// controllerPruned is reported as the controller of assets whose
+// RegisterAsset transaction was removed by prune.
+const controllerPruned = "unknown(pruned)"
+
+type assetReport struct {
+	id         common.Uint256
+	asset      *asset.Asset
+	controller string
+	issued     common.Fixed64
+	utxo       common.Fixed64
+	prepaid    common.Fixed64
+	// prepaidKnown is false when ST_Prepaid can not be split by asset
+	prepaidKnown bool
+}
+
+func NewAssetsCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "assets",
+		Usage:       "report issued and circulating supply per asset",
+		Description: "list every ST_Info asset with its ST_QuantityIssued total, the sum of its unspent outputs in IX_Unspent_UTXO and its share of ST_Prepaid, and flag assets where issued is not the sum of both",
+		Action:      assetsAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func assetsAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	reports, err := getAssetReports(st)
+	if err != nil {
+		return err
+	}
+
+	mismatches, unchecked, pruned := 0, 0, 0
+	for _, r := range reports {
+		if r.controller == controllerPruned {
+			pruned++
+		}
+		flag, prepaid, circulating := "ok", "unknown", "unknown"
+		if r.prepaidKnown {
+			prepaid = r.prepaid.String()
+			circulating = (r.utxo + r.prepaid).String()
+			if r.issued != r.utxo+r.prepaid {
+				flag = "MISMATCH"
+				mismatches++
+			}
+		} else {
+			flag = "UNCHECKED"
+			unchecked++
+		}
+		fmt.Printf("asset id:%s, name:%s, precision:%d, type:%d, controller:%s, issued:%s, utxo:%s, prepaid:%s, circulating:%s, %s\n",
+			hashString(r.id), r.asset.Name, r.asset.Precision, r.asset.AssetType, r.controller,
+			r.issued.String(), r.utxo.String(), prepaid, circulating, flag)
+	}
+	fmt.Printf("%d assets, %d with circulating supply different from issued\n", len(reports), mismatches)
+	if unchecked > 0 {
+		fmt.Printf("%d assets not checked, ST_Prepaid can not be split between the prepaid assets\n", unchecked)
+	}
+	if pruned > 0 {
+		fmt.Printf("%d assets with an unknown controller, their RegisterAsset transactions were pruned\n", pruned)
+	}
+
+	if mismatches > 0 {
+		return cli.NewExitError("", 1)
+	}
+
+	return nil
+}
+
+// getAssetReports reads every asset in key order with its issued total, the
+// sum of its unspent outputs and its prepaid balance.
+func getAssetReports(st store) ([]*assetReport, error) {
+	circulating, err := getCirculatingTotals(st)
+	if err != nil {
+		return nil, err
+	}
+	prepaid, prepaidKnown, err := getPrepaidByAsset(st)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*assetReport, 0)
+	iter := st.NewIterator([]byte{byte(db.ST_Info)})
+	defer iter.Release()
+	for iter.Next() {
+		id, err := common.Uint256ParseFromBytes(iter.Key()[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		a := new(asset.Asset)
+		if err := a.Deserialize(bytes.NewReader(iter.Value())); err != nil {
+			return nil, fmt.Errorf("asset %s: %v", hashString(id), err)
+		}
+
+		var issued common.Fixed64
+		if data, err := st.Get(append([]byte{byte(db.ST_QuantityIssued)}, id.ToArray()...)); err == nil {
+			if issued, err = parseIssued(data); err != nil {
+				return nil, fmt.Errorf("issued %s: %v", hashString(id), err)
+			}
+		}
+
+		// with an unknown split only assets that were never prepaid are
+		// checked, if no prepaid asset is known at all none are
+		_, isPrepaid := prepaid[hashString(id)]
+		reports = append(reports, &assetReport{
+			id:           id,
+			asset:        a,
+			controller:   getAssetController(st, id),
+			issued:       issued,
+			utxo:         circulating[hashString(id)],
+			prepaid:      prepaid[hashString(id)],
+			prepaidKnown: prepaidKnown || (len(prepaid) > 0 && !isPrepaid),
+		})
+	}
+
+	return reports, nil
+}
+
+// getAssetController reads the controller from the RegisterAsset
+// transaction, whose hash is the asset id. On a pruned db a missing
+// transaction is reported as controllerPruned.
+func getAssetController(st store, id common.Uint256) string {
+	txn, _, err := getTransaction(st, id)
+	if err != nil {
+		if _, err := getPrunedHeight(st); err == nil {
+			return controllerPruned
+		}
+		return "-"
+	}
+	register, ok := txn.Payload.(*payload.RegisterAsset)
+	if !ok {
+		return "-"
+	}
+
+	if addr, err := register.Controller.ToAddress(); err == nil {
+		return addr
+	}
+	return hex.EncodeToString(register.Controller.ToArray())
+}
+
+// getCirculatingTotals sums the unspent outputs of IX_Unspent_UTXO, keyed by
+// the hex asset id.
+func getCirculatingTotals(st store) (map[string]common.Fixed64, error) {
+	totals := make(map[string]common.Fixed64)
+	iter := st.NewIterator([]byte{byte(db.IX_Unspent_UTXO)})
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 57 {
+			return nil, fmt.Errorf("invalid utxo key %x", key)
+		}
+		assetID := hex.EncodeToString(key[21:53])
+
+		r := bytes.NewReader(iter.Value())
+		count, err := serialization.ReadVarUint(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < count; i++ {
+			u := new(tx.UTXOUnspent)
+			if err := u.Deserialize(r); err != nil {
+				return nil, err
+			}
+			totals[assetID] += u.Value
+		}
+	}
+
+	return totals, nil
+}
+
+// getPrepaidByAsset splits the ST_Prepaid total by asset, keyed by the hex
+// asset id. ST_Prepaid does not record the asset, so the split comes from
+// the Prepaid and Withdraw transactions of the main chain blocks. When they do not add up to
+// ST_Prepaid, for example on a pruned db, the total is only attributed if a
+// single asset was ever prepaid, otherwise known is false.
+func getPrepaidByAsset(st store) (map[string]common.Fixed64, bool, error) {
+	var total common.Fixed64
+	iter := st.NewIterator([]byte{byte(db.ST_Prepaid)})
+	for iter.Next() {
+		amount, _, err := parsePrepaid(iter.Value())
+		if err != nil {
+			iter.Release()
+			return nil, false, fmt.Errorf("prepaid %x: %v", iter.Key()[1:], err)
+		}
+		total += amount
+	}
+	iter.Release()
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// DATA_Transaction also holds transactions of orphan blocks, so only
+	// the transactions of main chain blocks are counted
+	prepaid := make(map[string]common.Fixed64)
+	for height := uint32(0); height <= current; height++ {
+		b, err := getBlockByHeight(st, height)
+		if err != nil {
+			return nil, false, fmt.Errorf("block %d: %v", height, err)
+		}
+
+		for _, txn := range b.Transactions {
+			switch txn.TxType {
+			case tx.Prepaid:
+				if prepaidPld, ok := txn.Payload.(*payload.Prepaid); ok {
+					prepaid[hashString(prepaidPld.Asset)] += prepaidPld.Amount
+				}
+			case tx.Withdraw:
+				if len(txn.Outputs) > 0 {
+					prepaid[hashString(txn.Outputs[0].AssetID)] -= txn.Outputs[0].Value
+				}
+			}
+		}
+	}
+
+	var sum common.Fixed64
+	for _, amount := range prepaid {
+		sum += amount
+	}
+	if sum == total {
+		return prepaid, true, nil
+	}
+
+	if len(prepaid) == 1 {
+		for id := range prepaid {
+			prepaid[id] = total
+		}
+		return prepaid, true, nil
+	}
+
+	return prepaid, false, nil
+}
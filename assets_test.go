@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/core/contract/program"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/core/transaction/payload"
+	"github.com/nknorg/nkn/db"
+)
+
+func TestPrepaidByAsset(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 100))
+	c.addBlock(t, a)
+
+	prepaid := &tx.Transaction{
+		TxType:   tx.Prepaid,
+		Payload:  &payload.Prepaid{Asset: testAsset, Amount: 30, Rates: 1},
+		Inputs:   []*tx.TxnInput{testInput(a, 0)},
+		Outputs:  []*tx.TxnOutput{testOutput(1, 70)},
+		Programs: []*program.Program{},
+	}
+	c.addBlock(t, prepaid)
+
+	value := bytes.NewBuffer(nil)
+	common.Fixed64(30).Serialize(value)
+	common.Fixed64(1).Serialize(value)
+	c.st.put(append([]byte{byte(db.ST_Prepaid)}, testProgramHash(1).ToArray()...), value.Bytes())
+
+	// a deposit of an orphan block is stored but not on the main chain
+	orphan := &tx.Transaction{
+		TxType:   tx.Prepaid,
+		Payload:  &payload.Prepaid{Asset: common.Uint256{0xbb}, Amount: 500, Rates: 1},
+		Inputs:   []*tx.TxnInput{testInput(a, 0)},
+		Outputs:  []*tx.TxnOutput{},
+		Programs: []*program.Program{},
+	}
+	orphanHash := orphan.Hash()
+	data := bytes.NewBuffer(heightBytes(1))
+	if err := orphan.Serialize(data); err != nil {
+		t.Fatal(err)
+	}
+	c.st.put(append([]byte{byte(db.DATA_Transaction)}, orphanHash.ToArray()...), data.Bytes())
+
+	byAsset, known, err := getPrepaidByAsset(c.st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !known || len(byAsset) != 1 || byAsset[hashString(testAsset)] != 30 {
+		t.Fatalf("prepaid %v, known %v, want 30 of the test asset", byAsset, known)
+	}
+
+	// ST_Prepaid that the transactions do not explain is still attributed
+	// to the only prepaid asset
+	value.Reset()
+	common.Fixed64(45).Serialize(value)
+	common.Fixed64(1).Serialize(value)
+	c.st.put(append([]byte{byte(db.ST_Prepaid)}, testProgramHash(1).ToArray()...), value.Bytes())
+	byAsset, known, err = getPrepaidByAsset(c.st)
+	if err != nil || !known || byAsset[hashString(testAsset)] != 45 {
+		t.Fatalf("prepaid %v, known %v, %v, want 45 of the test asset", byAsset, known, err)
+	}
+}
+
+func TestAssetControllerPruned(t *testing.T) {
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(1, 100)))
+
+	if got := getAssetController(c.st, testAsset); got != "-" {
+		t.Fatalf("controller of a missing transaction %q, want -", got)
+	}
+
+	c.st.put(prunedHeightKey, heightBytes(1))
+	if got := getAssetController(c.st, testAsset); got != controllerPruned {
+		t.Fatalf("controller on a pruned db %q, want %q", got, controllerPruned)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nkn/db"
+)
+
+const (
+	backupTimeFormat = "20060102150405"
+	backupBatchSize  = 10000
+)
+
+type backupInfo struct {
+	name   string
+	height uint32
+	time   time.Time
+}
+
+// backupName names a snapshot as <height>_<timestamp>.
+func backupName(height uint32, t time.Time) string {
+	return fmt.Sprintf("%d_%s", height, t.Format(backupTimeFormat))
+}
+
+func parseBackupName(name string) (*backupInfo, bool) {
+	parts := strings.Split(name, "_")
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	height, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, false
+	}
+
+	t, err := time.ParseInLocation(backupTimeFormat, parts[1], time.Local)
+	if err != nil {
+		return nil, false
+	}
+
+	return &backupInfo{name: name, height: uint32(height), time: t}, true
+}
+
+// backupStore copies every key of st into a new LevelDB store under dir and
+// returns the path of the snapshot. The copy is written under a .partial
+// name and renamed when complete, so an interrupted backup is never taken
+// for a snapshot by listBackups.
+func backupStore(st *db.LevelDBStore, dir string) (string, error) {
+	b, err := getCurrentBlock(st)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupName(b.Header.Height, time.Now()))
+	if exist, err := PathExists(path); err != nil {
+		return "", err
+	} else if exist {
+		return "", fmt.Errorf("backup %s already exists", path)
+	}
+
+	partial := path + ".partial"
+	if err := os.RemoveAll(partial); err != nil {
+		return "", err
+	}
+
+	bk, err := db.NewLevelDBStore(partial)
+	if err != nil {
+		return "", err
+	}
+
+	if err := copyStore(st, bk); err != nil {
+		bk.Close()
+		os.RemoveAll(partial)
+		return "", err
+	}
+
+	if err := bk.Close(); err != nil {
+		os.RemoveAll(partial)
+		return "", err
+	}
+
+	if err := os.Rename(partial, path); err != nil {
+		os.RemoveAll(partial)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func copyStore(src, dst *db.LevelDBStore) error {
+	if err := dst.NewBatch(); err != nil {
+		return err
+	}
+
+	count := 0
+	iter := src.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := append([]byte{}, iter.Key()...)
+		value := append([]byte{}, iter.Value()...)
+		if err := dst.BatchPut(key, value); err != nil {
+			return err
+		}
+
+		count++
+		if count%backupBatchSize == 0 {
+			if err := dst.BatchCommit(); err != nil {
+				return err
+			}
+			if err := dst.NewBatch(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return dst.BatchCommit()
+}
+
+func listBackups(dir string) ([]*backupInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*backupInfo, 0)
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if info, ok := parseBackupName(f.Name()); ok {
+			backups = append(backups, info)
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.Before(backups[j].time)
+	})
+
+	return backups, nil
+}
+
+// pruneBackups keeps the newest keep snapshots in dir and removes the rest.
+// A keep of 0 disables pruning.
+func pruneBackups(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+
+	backups, err := listBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	for len(backups) > keep {
+		path := filepath.Join(dir, backups[0].name)
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+		backups = backups[1:]
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListBackupsSkipsPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbtool-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	done := backupName(10, time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local))
+	partial := backupName(11, time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)) + ".partial"
+	for _, name := range []string{done, partial} {
+		if err := os.Mkdir(filepath.Join(dir, name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := listBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].name != done {
+		t.Fatalf("backups %+v, want only %s", backups, done)
+	}
+}
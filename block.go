@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/core/ledger"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+var txTypeNames = map[tx.TransactionType]string{
+	tx.TransferAsset: "TransferAsset",
+	tx.RegisterAsset: "RegisterAsset",
+	tx.IssueAsset:    "IssueAsset",
+	tx.Prepaid:       "Prepaid",
+	tx.Withdraw:      "Withdraw",
+}
+
+func NewBlockCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "block",
+		Usage:       "inspect blocks",
+		Description: "inspect blocks",
+		Subcommands: []cli.Command{
+			{
+				Name:        "show",
+				Usage:       "show a block by height or hash",
+				Description: "show a block by height or hash, the hash is the hex string of the header key",
+				ArgsUsage:   "<height|hash>",
+				Action:      blockShowAction,
+			},
+		},
+	}
+}
+
+func blockShowAction(c *cli.Context) error {
+	if c.NArg() < 1 {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	b, err := getBlockByHeightOrHash(st, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	return printBlock(os.Stdout, st, b)
+}
+
+func txTypeName(t tx.TransactionType) string {
+	if name, ok := txTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", byte(t))
+}
+
+// hashString is the hex of a hash as it appears in db keys. Every command
+// prints hashes with it and parseHash reads them back, so a hash printed by
+// one command can be passed to another.
+func hashString(hash common.Uint256) string {
+	return hex.EncodeToString(hash.ToArray())
+}
+
+// getBlockHash resolves a height to a block hash through DATA_BlockHash.
+func getBlockHash(st store, height uint32) (common.Uint256, error) {
+	key := make([]byte, 4)
+	binary.LittleEndian.PutUint32(key, height)
+	value, err := st.Get(append([]byte{byte(db.DATA_BlockHash)}, key...))
+	if err != nil {
+		return common.Uint256{}, err
+	}
+
+	return common.Uint256ParseFromBytes(value)
+}
+
+func getBlockByHash(st store, hash common.Uint256) (*ledger.Block, error) {
+	data, err := st.Get(append([]byte{byte(db.DATA_Header)}, hash.ToArray()...))
+	if err != nil {
+		return nil, err
+	}
+
+	return getBlock(st, data)
+}
+
+func getBlockByHeight(st store, height uint32) (*ledger.Block, error) {
+	hash, err := getBlockHash(st, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return getBlockByHash(st, hash)
+}
+
+// resolveBlockHash treats a 64 character argument as a hex block hash and
+// anything else as a height.
+func resolveBlockHash(st store, arg string) (common.Uint256, error) {
+	if len(arg) == 64 {
+		return parseHash(arg)
+	}
+
+	height, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil {
+		return common.Uint256{}, errors.New("invalid height or hash: " + arg)
+	}
+
+	return getBlockHash(st, uint32(height))
+}
+
+func parseHash(s string) (common.Uint256, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return common.Uint256{}, err
+	}
+
+	return common.Uint256ParseFromBytes(data)
+}
+
+func getBlockByHeightOrHash(st store, arg string) (*ledger.Block, error) {
+	hash, err := resolveBlockHash(st, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return getBlockByHash(st, hash)
+}
+
+func printBlock(w io.Writer, st store, b *ledger.Block) error {
+	h := b.Header
+	hash := b.Hash()
+
+	buff := bytes.NewBuffer(nil)
+	if err := b.Serialize(buff); err != nil {
+		return err
+	}
+
+	next := "-"
+	if nextHash, err := getBlockHash(st, h.Height+1); err == nil {
+		next = hashString(nextHash)
+	}
+
+	fmt.Fprintf(w, "Block %d\n", h.Height)
+	fmt.Fprintf(w, "  Hash:             %s\n", hashString(hash))
+	fmt.Fprintf(w, "  Previous:         %s\n", hashString(h.PrevBlockHash))
+	fmt.Fprintf(w, "  Next:             %s\n", next)
+	fmt.Fprintf(w, "  Size:             %d bytes\n", buff.Len())
+	fmt.Fprintf(w, "Header\n")
+	fmt.Fprintf(w, "  Version:          %d\n", h.Version)
+	fmt.Fprintf(w, "  Height:           %d\n", h.Height)
+	fmt.Fprintf(w, "  Timestamp:        %d (%s)\n", h.Timestamp, time.Unix(h.Timestamp, 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "  TransactionsRoot: %s\n", hashString(h.TransactionsRoot))
+	fmt.Fprintf(w, "  ConsensusData:    %d\n", h.ConsensusData)
+	fmt.Fprintf(w, "  NextBookKeeper:   %s\n", hex.EncodeToString(h.NextBookKeeper.ToArray()))
+	if h.Program != nil {
+		fmt.Fprintf(w, "Program\n")
+		fmt.Fprintf(w, "  Code:             %s\n", hex.EncodeToString(h.Program.Code))
+		fmt.Fprintf(w, "  Parameter:        %s\n", hex.EncodeToString(h.Program.Parameter))
+	}
+
+	fmt.Fprintf(w, "Transactions (%d)\n", len(b.Transactions))
+	for i, txn := range b.Transactions {
+		txHash := txn.Hash()
+		fmt.Fprintf(w, "  [%d] %s %s\n", i, hashString(txHash), txTypeName(txn.TxType))
+		fmt.Fprintf(w, "      inputs: %d, outputs: %d\n", len(txn.Inputs), len(txn.Outputs))
+		for _, output := range txn.Outputs {
+			fmt.Fprintf(w, "      -> %s %s asset:%s\n", hex.EncodeToString(output.ProgramHash.ToArray()), output.Value.String(), hashString(output.AssetID))
+		}
+	}
+
+	return nil
+}
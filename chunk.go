@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/DataDog/zstd"
+)
+
+// outputOptions controls compression and splitting of export files.
+type outputOptions struct {
+	compress     string
+	splitSize    int64
+	splitRecords int
+}
+
+// recordWriter receives the lines of an export together with their keys.
+type recordWriter interface {
+	writeRecord(key []byte, line string) error
+	Close() error
+}
+
+type chunkInfo struct {
+	File     string `json:"file"`
+	Records  int    `json:"records"`
+	FirstKey string `json:"firstKey"`
+	LastKey  string `json:"lastKey"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+type exportManifest struct {
+	Item     string       `json:"item"`
+	Compress string       `json:"compress,omitempty"`
+	Records  int          `json:"records"`
+	Chunks   []*chunkInfo `json:"chunks"`
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// chunkWriter writes export lines into one or more numbered chunk files and
+// a manifest describing them. Chunks rotate after splitRecords records or
+// splitSize uncompressed bytes.
+type chunkWriter struct {
+	name     string
+	opts     outputOptions
+	manifest *exportManifest
+
+	closer  io.Closer
+	comp    io.WriteCloser
+	buf     *bufio.Writer
+	sum     hash.Hash
+	count   *countWriter
+	current *chunkInfo
+	size    int64
+}
+
+func validateOutputOptions(opts outputOptions) error {
+	switch opts.compress {
+	case "", "gzip", "zstd":
+	default:
+		return fmt.Errorf("unknown compression %s, use gzip or zstd", opts.compress)
+	}
+	if opts.splitSize < 0 || opts.splitRecords < 0 {
+		return fmt.Errorf("split size and records can not be negative")
+	}
+
+	return nil
+}
+
+func (o outputOptions) plain() bool {
+	return o.compress == "" && o.splitSize == 0 && o.splitRecords == 0
+}
+
+func (o outputOptions) split() bool {
+	return o.splitSize > 0 || o.splitRecords > 0
+}
+
+func newChunkWriter(name string, item string, opts outputOptions) *chunkWriter {
+	return &chunkWriter{
+		name:     name,
+		opts:     opts,
+		manifest: &exportManifest{Item: item, Compress: opts.compress, Chunks: make([]*chunkInfo, 0)},
+	}
+}
+
+// chunkName returns the file name of the nth chunk, a plain export keeps
+// the given name.
+func (w *chunkWriter) chunkName(n int) string {
+	name := w.name
+	if w.opts.split() {
+		name = fmt.Sprintf("%s.%05d.txt", strings.TrimSuffix(name, ".txt"), n)
+	}
+
+	switch w.opts.compress {
+	case "gzip":
+		name += ".gz"
+	case "zstd":
+		name += ".zst"
+	}
+
+	return name
+}
+
+func (w *chunkWriter) open() error {
+	f, err := createFile(w.chunkName(len(w.manifest.Chunks)))
+	if err != nil {
+		return err
+	}
+
+	w.closer = f
+	w.sum = sha256.New()
+	w.count = &countWriter{w: io.MultiWriter(f, w.sum)}
+	w.current = &chunkInfo{File: w.chunkName(len(w.manifest.Chunks))}
+	w.size = 0
+
+	switch w.opts.compress {
+	case "gzip":
+		w.comp = gzip.NewWriter(w.count)
+	case "zstd":
+		w.comp = zstd.NewWriter(w.count)
+	default:
+		w.comp = nil
+	}
+
+	if w.comp != nil {
+		w.buf = bufio.NewWriter(w.comp)
+	} else {
+		w.buf = bufio.NewWriter(w.count)
+	}
+
+	return nil
+}
+
+func (w *chunkWriter) closeChunk() error {
+	if w.current == nil {
+		return nil
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if w.comp != nil {
+		if err := w.comp.Close(); err != nil {
+			return err
+		}
+	}
+	if err := w.closer.Close(); err != nil {
+		return err
+	}
+
+	w.current.Size = w.count.n
+	w.current.SHA256 = hex.EncodeToString(w.sum.Sum(nil))
+	w.manifest.Chunks = append(w.manifest.Chunks, w.current)
+	w.current = nil
+
+	return nil
+}
+
+func (w *chunkWriter) writeRecord(key []byte, line string) error {
+	if w.current != nil && w.opts.split() {
+		full := w.opts.splitRecords > 0 && w.current.Records >= w.opts.splitRecords
+		full = full || w.opts.splitSize > 0 && w.size+int64(len(line)) > w.opts.splitSize && w.current.Records > 0
+		if full {
+			if err := w.closeChunk(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if w.current == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.buf.WriteString(line); err != nil {
+		return err
+	}
+
+	k := hex.EncodeToString(key)
+	if w.current.Records == 0 {
+		w.current.FirstKey = k
+	}
+	w.current.LastKey = k
+	w.current.Records++
+	w.manifest.Records++
+	w.size += int64(len(line))
+
+	return nil
+}
+
+// Close finishes the last chunk and writes the manifest, an export without
+// compression or splitting keeps its single file and gets no manifest.
+func (w *chunkWriter) Close() error {
+	// an empty export still produces its file
+	if w.current == nil && len(w.manifest.Chunks) == 0 {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if err := w.closeChunk(); err != nil {
+		return err
+	}
+
+	if w.opts.plain() {
+		return nil
+	}
+
+	f, err := createFile(strings.TrimSuffix(w.name, ".txt") + ".manifest.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nknorg/nkn/db"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urfave/cli"
+)
+
+func NewCompactCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "compact",
+		Usage:       "compact the db to reclaim space",
+		Description: "trigger leveldb range compaction for the whole db or the key range of one item, and report the size before and after",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "only compact the key range of this item, same names as export",
+			},
+		},
+		Action: compactAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func compactAction(c *cli.Context) error {
+	path := c.GlobalString("path")
+	item := c.String("prefix")
+
+	r := util.Range{}
+	if item != "" {
+		prefix, ok := schema.prefixes[item]
+		if !ok {
+			return cli.NewExitError("unknown item "+item, 1)
+		}
+		r = *util.BytesPrefix([]byte{byte(prefix)})
+	}
+
+	if exist, err := PathExists(path); err != nil {
+		return err
+	} else if !exist {
+		return cli.NewExitError("db "+path+" does not exist", 1)
+	}
+
+	// LevelDBStore does not expose compaction, open the db directly with the
+	// options of NewLevelDBStore so that rewritten tables keep their filter
+	ldb, err := leveldb.OpenFile(path, &opt.Options{Filter: filter.NewBloomFilter(db.BITSPERKEY)})
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+
+	before, err := dirSize(path)
+	if err != nil {
+		return err
+	}
+	rangeBefore, err := ldb.SizeOf([]util.Range{r})
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := ldb.CompactRange(r); err != nil {
+		return err
+	}
+
+	after, err := dirSize(path)
+	if err != nil {
+		return err
+	}
+	rangeAfter, err := ldb.SizeOf([]util.Range{r})
+	if err != nil {
+		return err
+	}
+
+	if item != "" {
+		fmt.Printf("%s range: %d -> %d bytes\n", item, rangeBefore.Sum(), rangeAfter.Sum())
+	}
+	fmt.Printf("db size: %d -> %d bytes, reclaimed %d bytes in %s\n", before, after, before-after, time.Since(start))
+
+	return nil
+}
+
+// dirSize sums the size of all files under path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
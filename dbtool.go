@@ -20,6 +20,25 @@ func main() {
 	app.Commands = []cli.Command{
 		*NewExportCommand(),
 		*NewRollbackCommand(),
+		*NewBlockCommand(),
+		*NewShellCommand(),
+		*NewServeCommand(),
+		*NewMetricsCommand(),
+		*NewDecodeKeyCommand(),
+		*NewDecodeValueCommand(),
+		*NewMigrateCommand(),
+		*NewCompactCommand(),
+		*NewPruneCommand(),
+		*NewReindexCommand(),
+		*NewOrphansCommand(),
+		*NewVerifyCommand(),
+		*NewExportSQLCommand(),
+		*NewFollowCommand(),
+		*NewUTXOSnapshotCommand(),
+		*NewAssetsCommand(),
+		*NewPrepaidHistoryCommand(),
+		*NewAddrIndexCommand(),
+		*NewAddrHistoryCommand(),
 	}
 	app.Run(os.Args)
 }
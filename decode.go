@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/common/serialization"
+	"github.com/nknorg/nkn/core/asset"
+	"github.com/nknorg/nkn/core/ledger"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+// keyField is one typed field of a db key after the prefix byte.
+type keyField struct {
+	name string
+	kind string
+	size int
+}
+
+// keyLayouts describes the fields following the prefix byte of each key.
+var keyLayouts = map[db.DataEntryPrefix][]keyField{
+	db.DATA_BlockHash:    {{"height", "height", 4}},
+	db.DATA_Header:       {{"blockhash", "hash", 32}},
+	db.DATA_Transaction:  {{"txid", "hash", 32}},
+	db.IX_HeaderHashList: {{"index", "height", 4}},
+	db.IX_Unspent:        {{"txid", "hash", 32}},
+	db.IX_Unspent_UTXO:   {{"programhash", "programhash", 20}, {"assetid", "hash", 32}, {"height", "height", 4}},
+	db.ST_Info:           {{"assetid", "hash", 32}},
+	db.ST_QuantityIssued: {{"assetid", "hash", 32}},
+	db.ST_Prepaid:        {{"programhash", "programhash", 20}},
+	db.SYS_CurrentBlock:  {},
+	db.CFG_Version:       {},
+}
+
+// decoder turns a db value into the readable value written by export.
+type decoder func(st store, key []byte, value []byte) (interface{}, error)
+
+// itemPrefixes maps the item names accepted by export to their db prefix in
+// the current db version.
+var itemPrefixes = map[string]db.DataEntryPrefix{
+	"version":          db.CFG_Version,
+	"currentblockhash": db.SYS_CurrentBlock,
+	"asset":            db.ST_Info,
+	"issued":           db.ST_QuantityIssued,
+	"prepaid":          db.ST_Prepaid,
+	"blockhash":        db.DATA_BlockHash,
+	"header":           db.DATA_Header,
+	"transaction":      db.DATA_Transaction,
+	"unspent":          db.IX_Unspent,
+	"utxo":             db.IX_Unspent_UTXO,
+	"headerlist":       db.IX_HeaderHashList,
+	"block":            db.DATA_Header,
+}
+
+var itemDecoders = map[string]decoder{
+	"version":          decodeVersion,
+	"currentblockhash": decodeCurrentBlock,
+	"asset":            decodeAsset,
+	"issued":           decodeIssued,
+	"prepaid":          decodePrepaid,
+	"blockhash":        decodeBlockhash,
+	"header":           decodeHeader,
+	"transaction":      decodeTransaction,
+	"unspent":          decodeUnspent,
+	"utxo":             decodeUTXO,
+	"headerlist":       decodeHeaderlist,
+	"block":            decodeBlock,
+}
+
+// itemNames returns the item names in sorted order.
+func itemNames() []string {
+	names := make([]string, 0, len(schema.prefixes))
+	for name := range schema.prefixes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// itemKey builds the db key of item from its hex encoded key suffix.
+func itemKey(item string, keystr string) ([]byte, error) {
+	prefix, ok := schema.prefixes[item]
+	if !ok {
+		return nil, errors.New("unknown item: " + item)
+	}
+
+	key, err := hex.DecodeString(keystr)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{byte(prefix)}, key...), nil
+}
+
+func decodeItem(st store, item string, key []byte, value []byte) (interface{}, error) {
+	decode, ok := schema.decoders[item]
+	if !ok {
+		return nil, errors.New("unknown item: " + item)
+	}
+
+	return decode(st, key, value)
+}
+
+// The parse functions read the values of the db items into their nkn types,
+// they are shared by the decoders and by export-sql.
+
+func parseCurrentBlock(data []byte) (common.Uint256, uint32, error) {
+	r := bytes.NewReader(data)
+	var hash common.Uint256
+	if err := hash.Deserialize(r); err != nil {
+		return common.Uint256{}, 0, err
+	}
+	height, err := serialization.ReadUint32(r)
+	if err != nil {
+		return common.Uint256{}, 0, err
+	}
+
+	return hash, height, nil
+}
+
+func parseAsset(data []byte) (*asset.Asset, error) {
+	ass := new(asset.Asset)
+	if err := ass.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	return ass, nil
+}
+
+func parseIssued(data []byte) (common.Fixed64, error) {
+	var amount common.Fixed64
+	if err := amount.Deserialize(bytes.NewReader(data)); err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+func parsePrepaid(data []byte) (common.Fixed64, common.Fixed64, error) {
+	var amount, rates common.Fixed64
+	r := bytes.NewReader(data)
+	if err := amount.Deserialize(r); err != nil {
+		return 0, 0, err
+	}
+	if err := rates.Deserialize(r); err != nil {
+		return 0, 0, err
+	}
+
+	return amount, rates, nil
+}
+
+func parseBlockhashKey(key []byte) (uint32, error) {
+	if len(key) != 5 {
+		return 0, errors.New("invalid blockhash key")
+	}
+
+	return binary.LittleEndian.Uint32(key[1:]), nil
+}
+
+// parseHeaderFee reads the system fee stored in front of a DATA_Header value.
+func parseHeaderFee(data []byte) (common.Fixed64, error) {
+	if len(data) < schema.headerPrefixSize {
+		return 0, errors.New("invalid header value")
+	}
+
+	var fee common.Fixed64
+	if err := fee.Deserialize(bytes.NewReader(data[:schema.headerPrefixSize])); err != nil {
+		return 0, err
+	}
+
+	return fee, nil
+}
+
+func parseHeader(data []byte) (*ledger.Header, error) {
+	if len(data) < schema.headerPrefixSize {
+		return nil, errors.New("invalid header value")
+	}
+
+	h := new(ledger.Header)
+	if err := h.Deserialize(bytes.NewReader(data[schema.headerPrefixSize:])); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// parseTrimmedBlock reads a DATA_Header value into a block whose
+// transactions only carry their hash.
+func parseTrimmedBlock(data []byte) (*ledger.Block, error) {
+	if len(data) < schema.headerPrefixSize {
+		return nil, errors.New("invalid header value")
+	}
+
+	b := new(ledger.Block)
+	if err := b.FromTrimmedData(bytes.NewReader(data[schema.headerPrefixSize:])); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func parseTransaction(data []byte) (uint32, *tx.Transaction, error) {
+	r := bytes.NewReader(data)
+	height, err := serialization.ReadUint32(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	txn := new(tx.Transaction)
+	if err := txn.Deserialize(r); err != nil {
+		return 0, nil, err
+	}
+
+	return height, txn, nil
+}
+
+func parseUTXOKey(key []byte) (common.Uint160, common.Uint256, uint32, error) {
+	if len(key) != 57 {
+		return common.Uint160{}, common.Uint256{}, 0, fmt.Errorf("invalid utxo key %x", key)
+	}
+	programHash, err := common.Uint160ParseFromBytes(key[1:21])
+	if err != nil {
+		return common.Uint160{}, common.Uint256{}, 0, err
+	}
+	assetID, err := common.Uint256ParseFromBytes(key[21:53])
+	if err != nil {
+		return common.Uint160{}, common.Uint256{}, 0, err
+	}
+
+	return programHash, assetID, binary.LittleEndian.Uint32(key[53:]), nil
+}
+
+func parseUTXOList(data []byte) ([]*tx.UTXOUnspent, error) {
+	r := bytes.NewReader(data)
+	count, err := serialization.ReadVarUint(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	unspents := make([]*tx.UTXOUnspent, 0, count)
+	for i := uint64(0); i < count; i++ {
+		u := new(tx.UTXOUnspent)
+		if err := u.Deserialize(r); err != nil {
+			return nil, err
+		}
+		unspents = append(unspents, u)
+	}
+
+	return unspents, nil
+}
+
+func decodeVersion(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Version string `json:"version"`
+	}
+
+	return value{hex.EncodeToString(data)}, nil
+}
+
+func decodeCurrentBlock(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Hash   string `json:"hash"`
+		Height uint32 `json:"height"`
+	}
+
+	hash, height, err := parseCurrentBlock(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value{hashString(hash), height}, nil
+}
+
+func decodeAsset(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Ass asset.Asset `json:"asset"`
+	}
+
+	ass, err := parseAsset(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value{*ass}, nil
+}
+
+func decodeIssued(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Amount common.Fixed64 `json:"amount"`
+	}
+
+	amount, err := parseIssued(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value{amount}, nil
+}
+
+func decodePrepaid(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Amount common.Fixed64 `json:"amount"`
+		Rates  common.Fixed64 `json:"rates"`
+	}
+
+	amount, rates, err := parsePrepaid(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value{amount, rates}, nil
+}
+
+func decodeBlockhash(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Hash   string `json:"hash"`
+		Height uint32 `json:"height"`
+	}
+
+	height, err := parseBlockhashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return value{hex.EncodeToString(data), height}, nil
+}
+
+func decodeHeader(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Header string `json:"header"`
+	}
+
+	h, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	headerMarshal, err := h.MarshalJson()
+	if err != nil {
+		return nil, err
+	}
+
+	return value{string(headerMarshal)}, nil
+}
+
+func decodeTransaction(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Height      uint32 `json:"height"`
+		Transaction string `json:"transaction"`
+	}
+
+	height, txn, err := parseTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	txMarshal, err := txn.MarshalJson()
+	if err != nil {
+		return nil, err
+	}
+
+	return value{height, string(txMarshal)}, nil
+}
+
+func decodeUnspent(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Index string `json:"index"`
+	}
+
+	unspentArray, err := common.GetUint16Array(data)
+	if err != nil {
+		return nil, err
+	}
+	unspentMarshal, _ := json.Marshal(unspentArray)
+
+	return value{string(unspentMarshal)}, nil
+}
+
+func decodeUTXO(st store, key []byte, data []byte) (interface{}, error) {
+	type utxo struct {
+		Txid  string         `json:"txid"`
+		Index uint32         `json:"index"`
+		Value common.Fixed64 `json:"value"`
+	}
+	type value struct {
+		UTXO []utxo `json:"utxo"`
+	}
+
+	list, err := parseUTXOList(data)
+	if err != nil {
+		return nil, err
+	}
+
+	unspents := make([]utxo, 0)
+	for _, uu := range list {
+		u := utxo{
+			Txid:  hashString(uu.Txid),
+			Index: uu.Index,
+			Value: uu.Value,
+		}
+		unspents = append(unspents, u)
+	}
+
+	return value{unspents}, nil
+}
+
+func decodeHeaderlist(st store, key []byte, data []byte) (interface{}, error) {
+	type headerlist struct {
+		Amount uint64   `json:"amount"`
+		List   []string `json:"list"`
+	}
+
+	r := bytes.NewReader(data)
+	amount, err := serialization.ReadVarUint(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	headerIndex := make([]string, 0)
+	for i := 0; i < int(amount); i++ {
+		var listHash common.Uint256
+		if err := listHash.Deserialize(r); err != nil {
+			return nil, err
+		}
+		headerIndex = append(headerIndex, hashString(listHash))
+	}
+
+	return headerlist{amount, headerIndex}, nil
+}
+
+func decodeBlock(st store, key []byte, data []byte) (interface{}, error) {
+	type value struct {
+		Block string `json:"block"`
+	}
+
+	b, err := getBlock(st, data)
+	if err != nil {
+		return nil, err
+	}
+	blockMarshal, err := b.MarshalJson()
+	if err != nil {
+		return nil, err
+	}
+
+	return value{string(blockMarshal)}, nil
+}
+
+type decodedField struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Address string `json:"address,omitempty"`
+}
+
+type decodedKey struct {
+	Prefix string         `json:"prefix"`
+	Item   string         `json:"item"`
+	Fields []decodedField `json:"fields"`
+	Rest   string         `json:"rest,omitempty"`
+}
+
+func NewDecodeKeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "decode-key",
+		Usage:       "decode a raw db key",
+		Description: "identify the prefix of a hex db key and split the rest into typed fields",
+		ArgsUsage:   "<hex>",
+		Action:      decodeKeyAction,
+	}
+}
+
+func NewDecodeValueCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "decode-value",
+		Usage:       "decode a raw db value",
+		Description: "decode a hex db value with the decoder of item, block values are completed from the db",
+		ArgsUsage:   "<hex>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "item, i",
+				Usage: "the item of the value. include " + strings.Join(itemNames(), ", "),
+			},
+			cli.StringFlag{
+				Name:  "key, k",
+				Usage: "the full hex key of the value, needed by blockhash",
+			},
+		},
+		Action: decodeValueAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func decodeKeyAction(c *cli.Context) error {
+	if c.NArg() < 1 {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	key, err := hex.DecodeString(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	dk, err := decodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(os.Stdout, dk)
+}
+
+func decodeKey(key []byte) (*decodedKey, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty key")
+	}
+
+	dk := &decodedKey{
+		Prefix: hex.EncodeToString(key[:1]),
+		Item:   prefixName(key[0]),
+		Fields: make([]decodedField, 0),
+	}
+
+	rest := key[1:]
+	for _, f := range keyLayouts[db.DataEntryPrefix(key[0])] {
+		if len(rest) < f.size {
+			break
+		}
+		data := rest[:f.size]
+		rest = rest[f.size:]
+
+		field := decodedField{Name: f.name, Type: f.kind, Value: hex.EncodeToString(data)}
+		switch f.kind {
+		case "height":
+			field.Value = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data)), 10)
+		case "programhash":
+			programHash, err := common.Uint160ParseFromBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			if addr, err := programHash.ToAddress(); err == nil {
+				field.Address = addr
+			}
+		}
+		dk.Fields = append(dk.Fields, field)
+	}
+	dk.Rest = hex.EncodeToString(rest)
+
+	return dk, nil
+}
+
+func decodeValueAction(c *cli.Context) error {
+	item := c.String("item")
+	if c.NArg() < 1 || item == "" {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	value, err := hex.DecodeString(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	key, err := hex.DecodeString(c.String("key"))
+	if err != nil {
+		return err
+	}
+
+	var st store
+	if item == "block" {
+		ldb, err := openStore(c.GlobalString("path"))
+		if err != nil {
+			return err
+		}
+		defer ldb.Close()
+		st = ldb
+	}
+
+	v, err := decodeItem(st, item, key, value)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(os.Stdout, current{Key: hex.EncodeToString(key), Value: v})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nknorg/nkn/db"
+)
+
+func TestParseChainValues(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 100), testOutput(1, 20))
+	b := c.addBlock(t, a)
+
+	hash, height, err := parseCurrentBlock(c.st.data[string([]byte{byte(db.SYS_CurrentBlock)})])
+	if err != nil || hash != b.Hash() || height != 0 {
+		t.Fatalf("current block %s %d, %v", hashString(hash), height, err)
+	}
+
+	decoded, err := decodeCurrentBlock(c.st, nil, c.st.data[string([]byte{byte(db.SYS_CurrentBlock)})])
+	if err != nil {
+		t.Fatal(err)
+	}
+	printed := reflect.ValueOf(decoded).FieldByName("Hash").String()
+	if parsed, err := parseHash(printed); err != nil || parsed != hash {
+		t.Fatalf("decoded current block hash %s does not parse back to %s", printed, hashString(hash))
+	}
+
+	trimmed, err := parseTrimmedBlock(c.st.data[string(append([]byte{byte(db.DATA_Header)}, hash.ToArray()...))])
+	if err != nil || len(trimmed.Transactions) != 1 || trimmed.Transactions[0].Hash() != a.Hash() {
+		t.Fatalf("trimmed block %v, %v", trimmed, err)
+	}
+
+	key := testUTXOKey(a.Outputs[0], 0)
+	programHash, assetID, utxoHeight, err := parseUTXOKey(key)
+	if err != nil || programHash != testProgramHash(1) || assetID != testAsset || utxoHeight != 0 {
+		t.Fatalf("utxo key %x: %v", key, err)
+	}
+	unspents, err := parseUTXOList(c.st.data[string(key)])
+	if err != nil || len(unspents) != 2 || unspents[1].Value != 20 {
+		t.Fatalf("utxo list %v, %v", unspents, err)
+	}
+
+	if _, _, _, err := parseUTXOKey(key[:56]); err == nil {
+		t.Fatal("short utxo key parsed")
+	}
+	if _, err := parseHeader([]byte{0x01}); err == nil {
+		t.Fatal("short header value parsed")
+	}
+}
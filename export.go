@@ -3,16 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
 
-	"github.com/nknorg/nkn/common"
-	"github.com/nknorg/nkn/common/serialization"
-	"github.com/nknorg/nkn/core/asset"
 	"github.com/nknorg/nkn/core/ledger"
-	tx "github.com/nknorg/nkn/core/transaction"
 	"github.com/nknorg/nkn/db"
 	"github.com/urfave/cli"
 )
@@ -42,6 +38,36 @@ func NewExportCommand() *cli.Command {
 				Name:  "key, k",
 				Usage: "the key of item, hex string",
 			},
+			cli.IntFlag{
+				Name:  "workers, w",
+				Usage: "number of workers exporting key shards concurrently",
+				Value: 1,
+			},
+			cli.BoolFlag{
+				Name:  "shard-files",
+				Usage: "keep one output file per shard instead of merging them in key order",
+			},
+			cli.StringFlag{
+				Name:  "compress, c",
+				Usage: "compress output files with gzip or zstd",
+			},
+			cli.Int64Flag{
+				Name:  "split-size",
+				Usage: "start a new output file after this many uncompressed bytes, 0 disables",
+			},
+			cli.IntFlag{
+				Name:  "split-records",
+				Usage: "start a new output file after this many records, 0 disables",
+			},
+			cli.IntFlag{
+				Name:  "since-height",
+				Usage: "export blocks and transactions from this height on together with the state entries they changed, ignores item and key",
+				Value: -1,
+			},
+			cli.StringFlag{
+				Name:  "state-file",
+				Usage: "record the last exported block in this file and continue after it when since-height is not set",
+			},
 		},
 		Action: exportAction,
 		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
@@ -51,6 +77,10 @@ func NewExportCommand() *cli.Command {
 }
 
 func exportAction(c *cli.Context) (err error) {
+	if c.Int("since-height") >= 0 || c.String("state-file") != "" {
+		return exportIncrementalAction(c)
+	}
+
 	if c.NumFlags() < 2 {
 		cli.ShowSubcommandHelp(c)
 		return nil
@@ -61,58 +91,71 @@ func exportAction(c *cli.Context) (err error) {
 	keystr := c.String("key")
 	israw := c.Bool("raw")
 	key, _ := hex.DecodeString(keystr)
+	opts, err := exportOutputOptions(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 
-	st, err := db.NewLevelDBStore(path)
+	st, err := openStore(path)
 	if err != nil {
 		return err
 	}
 
-	//TODO block ,trimedblock
-	switch item {
-	case "version":
-		prefix := []byte{byte(db.CFG_Version)}
-		err = exportVersion(item+"_"+keystr+".txt", st, prefix, israw)
-	case "currentblockhash":
-		prefix := []byte{byte(db.SYS_CurrentBlock)}
-		err = exportCurrentBlock(item+"_"+keystr+".txt", st, prefix, israw)
-	case "asset":
-		prefix := append([]byte{byte(db.ST_Info)}, key...)
-		err = exportAsset(item+"_"+keystr+".txt", st, prefix, israw)
-	case "issued":
-		prefix := append([]byte{byte(db.ST_QuantityIssued)}, key...)
-		err = exportIssued(item+"_"+keystr+".txt", st, prefix, israw)
-	case "prepaid":
-		prefix := append([]byte{byte(db.ST_Prepaid)}, key...)
-		err = exportPrepaid(item+"_"+keystr+".txt", st, prefix, israw)
-	case "blockhash":
-		prefix := append([]byte{byte(db.DATA_BlockHash)}, key...)
-		err = exportBlockhash(item+"_"+keystr+".txt", st, prefix, israw)
-	case "header":
-		prefix := append([]byte{byte(db.DATA_Header)}, key...)
-		err = exportHeader(item+"_"+keystr+".txt", st, prefix, israw)
-	case "transaction":
-		prefix := append([]byte{byte(db.DATA_Transaction)}, key...)
-		err = exportTransaction(item+"_"+keystr+".txt", st, prefix, israw)
-	case "unspent":
-		prefix := append([]byte{byte(db.IX_Unspent)}, key...)
-		err = exportUnspent(item+"_"+keystr+".txt", st, prefix, israw)
-	case "utxo":
-		prefix := append([]byte{byte(db.IX_Unspent_UTXO)}, key...)
-		err = exportUTXO(item+"_"+keystr+".txt", st, prefix, israw)
-	case "headerlist":
-		prefix := append([]byte{byte(db.IX_HeaderHashList)}, key...)
-		err = exportHeaderlist(item+"_"+keystr+".txt", st, prefix, israw)
-	case "block":
-		prefix := append([]byte{byte(db.DATA_Header)}, key...)
-		err = exportBlock(item+"_"+keystr+".txt", st, prefix, israw)
-	default:
+	//TODO trimedblock
+	prefix, ok := schema.prefixes[item]
+	if !ok {
 		cli.ShowSubcommandHelp(c)
+		st.Close()
+		return nil
+	}
+
+	// version and currentblockhash are single keys without a suffix
+	key = append([]byte{byte(prefix)}, key...)
+	if item == "version" || item == "currentblockhash" {
+		key = []byte{byte(prefix)}
+	}
+
+	filename := item + "_" + keystr + ".txt"
+	workers := c.Int("workers")
+	if workers > 1 && item != "version" && item != "currentblockhash" {
+		err = exportParallel(filename, st, item, key, israw, workers, c.Bool("shard-files"), opts)
+	} else {
+		err = exportItem(filename, st, item, key, israw, opts)
 	}
 
 	st.Close()
 	return err
 }
 
+func exportIncrementalAction(c *cli.Context) error {
+	opts, err := exportOutputOptions(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if err := exportIncremental(st, c.Int("since-height"), c.String("state-file"), c.Bool("raw"), opts); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func exportOutputOptions(c *cli.Context) (outputOptions, error) {
+	opts := outputOptions{
+		compress:     c.String("compress"),
+		splitSize:    c.Int64("split-size"),
+		splitRecords: c.Int("split-records"),
+	}
+
+	return opts, validateOutputOptions(opts)
+}
+
 func writeDBItermToFile(filename string, dbPath string, key []byte) error {
 	st, err := db.NewLevelDBStore(dbPath)
 	if err != nil {
@@ -174,416 +217,72 @@ func createFile(name string) (*os.File, error) {
 	return f, nil
 }
 
-func exportVersion(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Version string `json:"version"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
+func exportItem(filename string, st *db.LevelDBStore, item string, key []byte, israw bool, opts outputOptions) error {
+	w := newChunkWriter(filename, item, opts)
 	iter := st.NewIterator(key)
+	defer iter.Release()
 	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{hex.EncodeToString(iter.Value())}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportCurrentBlock(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Hash   string `json:"hash"`
-		Height uint32 `json:"height"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			r := bytes.NewReader(iter.Value())
-			var hash common.Uint256
-			hash.Deserialize(r)
-			height, _ := serialization.ReadUint32(r)
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{hash.ToHexString(), height}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportAsset(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Ass asset.Asset `json:"asset"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			ass := new(asset.Asset)
-			r := bytes.NewReader(iter.Value())
-			ass.Deserialize(r)
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{*ass}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportIssued(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Amount common.Fixed64 `json:"amount"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			var amount common.Fixed64
-			r := bytes.NewReader(iter.Value())
-			amount.Deserialize(r)
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{amount}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportPrepaid(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Amount common.Fixed64 `json:"amount"`
-		Rates  common.Fixed64 `json:"rates"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			var amount, rates common.Fixed64
-			r := bytes.NewReader(iter.Value())
-			amount.Deserialize(r)
-			rates.Deserialize(r)
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{amount, rates}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportBlockhash(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Hash   string `json:"hash"`
-		Height uint32 `json:"height"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			height := binary.LittleEndian.Uint32(iter.Key()[1:])
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{hex.EncodeToString(iter.Value()), height}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportHeader(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Header string `json:"header"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			var h = new(ledger.Header)
-			r := bytes.NewReader(iter.Value())
-			serialization.ReadUint64(r)
-			h.Deserialize(r)
-			headerMarshal, _ := h.MarshalJson()
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{string(headerMarshal)}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportTransaction(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Height      uint32 `json:"height"`
-		Transaction string `json:"transaction"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			var txn tx.Transaction
-			r := bytes.NewReader(iter.Value())
-			height, _ := serialization.ReadUint32(r)
-			txn.Deserialize(r)
-
-			txMarshal, _ := txn.MarshalJson()
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{height, string(txMarshal)}})
-			w.WriteString(string(data) + "\n")
+		if err := w.writeRecord(iter.Key(), exportLine(st, item, iter.Key(), iter.Value(), israw)); err != nil {
+			return err
 		}
 	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
-
-func exportUnspent(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Index string `json:"index"`
-	}
 
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			unspentArray, _ := common.GetUint16Array(iter.Value())
-			unspentMarshal, _ := json.Marshal(unspentArray)
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{string(unspentMarshal)}})
-			w.WriteString(string(data) + "\n")
-		}
-	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
+	return w.Close()
 }
 
-func exportUTXO(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type utxo struct {
-		Txid  string         `json:"txid"`
-		Index uint32         `json:"index"`
-		Value common.Fixed64 `json:"value"`
-	}
-	type value struct {
-		UTXO []utxo `json:"utxo"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			r := bytes.NewReader(iter.Value())
-			listNum, _ := serialization.ReadVarUint(r, 0)
-
-			unspents := make([]utxo, 0)
-			for i := 0; i < int(listNum); i++ {
-				uu := new(tx.UTXOUnspent)
-				uu.Deserialize(r)
-				u := utxo{
-					Txid:  uu.Txid.ToHexString(),
-					Index: uu.Index,
-					Value: uu.Value,
-				}
-				unspents = append(unspents, u)
+// exportLine formats one db entry as a line of an export file. Entries that
+// fail to decode are logged and written in the raw form, so that one bad
+// entry does not stop the export.
+func exportLine(st store, item string, key []byte, value []byte, israw bool) string {
+	if !israw {
+		v, err := decodeItem(st, item, key, value)
+		if err == nil {
+			data, err := json.Marshal(current{Key: hex.EncodeToString(key), Value: v})
+			if err == nil {
+				return string(data) + "\n"
 			}
-
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{unspents}})
-			w.WriteString(string(data) + "\n")
 		}
+		fmt.Fprintf(os.Stderr, "export %s %x: %v, written raw\n", item, key, err)
+		return hex.EncodeToString(key) + "," + hex.EncodeToString(value) + "\n"
 	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
 
-func exportHeaderlist(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type headerlist struct {
-		Amount uint64   `json:"amount"`
-		List   []string `json:"list"`
-	}
-
-	f, err := createFile(filename)
-	if err != nil {
-		return err
-	}
-
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		if israw {
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(iter.Value()) + "\n")
-
-		} else {
-			r := bytes.NewReader(iter.Value())
-			amount, _ := serialization.ReadVarUint(r, 0)
-
-			headerIndex := make([]string, 0)
-			for i := 0; i < int(amount); i++ {
-				var listHash common.Uint256
-				listHash.Deserialize(r)
-				str := listHash.ToHexString()
-				headerIndex = append(headerIndex, str)
-			}
-
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: headerlist{amount, headerIndex}})
-			w.WriteString(string(data) + "\n")
+	// raw blocks are written with their full transactions
+	if item == "block" {
+		b, err := getBlock(st, value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export %s %x: %v, written without transactions\n", item, key, err)
+			return hex.EncodeToString(key) + "," + hex.EncodeToString(value) + "\n"
 		}
+		buff := bytes.NewBuffer(nil)
+		b.Serialize(buff)
+		value = buff.Bytes()
 	}
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
-}
 
-func exportBlock(filename string, st *db.LevelDBStore, key []byte, israw bool) error {
-	type value struct {
-		Block string `json:"block"`
-	}
+	return hex.EncodeToString(key) + "," + hex.EncodeToString(value) + "\n"
+}
 
-	f, err := createFile(filename)
+// getBlock rebuilds a full block from a DATA_Header value and the
+// DATA_Transaction entries of its transactions.
+func getBlock(st store, data []byte) (*ledger.Block, error) {
+	b, err := parseTrimmedBlock(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	w := bufio.NewWriter(f)
-	iter := st.NewIterator(key)
-	for iter.Next() {
-		b := new(ledger.Block)
-		r := bytes.NewReader(iter.Value())
-		serialization.ReadUint64(r)
-		if err = b.FromTrimmedData(r); err != nil {
-			return err
-		}
-
-		for i := 0; i < len(b.Transactions); i++ {
-			hash := b.Transactions[i].Hash()
-			value, err := st.Get(append([]byte{byte(db.DATA_Transaction)}, hash.ToArray()...))
-			if err != nil {
-				return err
-			}
-
-			txn := new(tx.Transaction)
-			if err := txn.Deserialize(bytes.NewReader(value[4:])); err != nil {
-				return err
-			}
-
-			b.Transactions[i] = txn
+	for i := 0; i < len(b.Transactions); i++ {
+		hash := b.Transactions[i].Hash()
+		value, err := st.Get(append([]byte{byte(db.DATA_Transaction)}, hash.ToArray()...))
+		if err != nil {
+			return nil, err
 		}
 
-		if israw {
-			buff := bytes.NewBuffer(nil)
-			b.Serialize(buff)
-			w.WriteString(hex.EncodeToString(iter.Key()) + "," + hex.EncodeToString(buff.Bytes()) + "\n")
-		} else {
-			blockMarshal, _ := b.MarshalJson()
-			data, _ := json.Marshal(current{Key: hex.EncodeToString(iter.Key()), Value: value{string(blockMarshal)}})
-			w.WriteString(string(data) + "\n")
+		_, txn, err := parseTransaction(value)
+		if err != nil {
+			return nil, err
 		}
 
+		b.Transactions[i] = txn
 	}
 
-	iter.Release()
-	w.Flush()
-	f.Close()
-	return nil
+	return b, nil
 }
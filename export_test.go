@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nknorg/nkn/db"
+)
+
+func TestExportLineFallsBackToRaw(t *testing.T) {
+	st := newMemStore()
+	key := append([]byte{byte(db.DATA_Header)}, make([]byte, 32)...)
+	value := []byte{0x01, 0x02}
+
+	want := "01" + "0000000000000000000000000000000000000000000000000000000000000000" + ",0102\n"
+	if got := exportLine(st, "block", key, value, false); got != want {
+		t.Fatalf("decoded line %q, want raw %q", got, want)
+	}
+	if got := exportLine(st, "block", key, value, true); got != want {
+		t.Fatalf("raw line %q, want %q", got, want)
+	}
+}
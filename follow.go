@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/db"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urfave/cli"
+)
+
+// followHistory is the number of emitted block hashes kept to find the fork
+// height of a reorg.
+const followHistory = 1000
+
+var errReadOnly = errors.New("read only store")
+
+// errDBLocked is returned when the db can not be opened read only because a
+// running node holds its lock.
+var errDBLocked = errors.New("the db is locked by a running node, use --snapshot-dir to read a snapshot of a live db")
+
+// readOnlyStore serves the store interface from a goleveldb handle opened
+// read only, writes are refused.
+type readOnlyStore struct {
+	ldb *leveldb.DB
+}
+
+func (r *readOnlyStore) Get(key []byte) ([]byte, error) {
+	return r.ldb.Get(key, nil)
+}
+
+func (r *readOnlyStore) NewIterator(prefix []byte) db.IIterator {
+	return r.ldb.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (r *readOnlyStore) NewBatch() error                  { return errReadOnly }
+func (r *readOnlyStore) BatchPut(key, value []byte) error { return errReadOnly }
+func (r *readOnlyStore) BatchDelete(key []byte) error     { return errReadOnly }
+func (r *readOnlyStore) BatchCommit() error               { return errReadOnly }
+
+type followEvent struct {
+	Type       string          `json:"type"`
+	Height     uint32          `json:"height"`
+	Hash       string          `json:"hash,omitempty"`
+	FromHeight uint32          `json:"fromHeight,omitempty"`
+	Block      json.RawMessage `json:"block,omitempty"`
+}
+
+// follower remembers the recently emitted blocks, so that a changed hash at
+// a known height can be reported as a rollback to the fork.
+type follower struct {
+	out     *json.Encoder
+	hashes  map[uint32]common.Uint256
+	next    uint32
+	started bool
+	blocks  bool
+	loaded  bool
+}
+
+func NewFollowCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "follow",
+		Usage:       "stream new blocks and rollbacks as JSON lines",
+		Description: "poll SYS_CurrentBlock and print every new block, or a rollback event when the chain tip moves back, as one JSON line on stdout",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "interval",
+				Usage: "seconds between polls",
+				Value: 2,
+			},
+			cli.IntFlag{
+				Name:  "from-height",
+				Usage: "emit blocks from this height on, by default only blocks after the current tip",
+				Value: -1,
+			},
+			cli.StringFlag{
+				Name:  "snapshot-dir",
+				Usage: "poll a snapshot refreshed in this directory instead of opening the db read only, needed while a node is running on the db",
+			},
+			cli.BoolFlag{
+				Name:  "headers-only",
+				Usage: "emit block heights and hashes without the block content",
+			},
+		},
+		Action: followAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func followAction(c *cli.Context) error {
+	path := c.GlobalString("path")
+	interval := c.Int("interval")
+	if interval <= 0 {
+		return cli.NewExitError("interval must be positive", 1)
+	}
+	snapshotDir := c.String("snapshot-dir")
+
+	f := &follower{
+		out:    json.NewEncoder(os.Stdout),
+		hashes: make(map[uint32]common.Uint256),
+		blocks: !c.Bool("headers-only"),
+	}
+	if from := c.Int("from-height"); from >= 0 {
+		f.next = uint32(from)
+		f.started = true
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := f.poll(path, snapshotDir); err == errDBLocked {
+			return cli.NewExitError(err.Error(), 1)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "follow: %v\n", err)
+		}
+
+		select {
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// openFollowStore opens the db read only, or refreshes snapshotDir from it
+// and opens the snapshot. A read only open shares the lock with other
+// readers, so it fails with errDBLocked while a node has the db open.
+func openFollowStore(path string, snapshotDir string) (*leveldb.DB, error) {
+	if snapshotDir != "" {
+		snapshot := filepath.Join(snapshotDir, "follow-snapshot")
+		if err := refreshSnapshot(path, snapshot); err != nil {
+			return nil, err
+		}
+		path = snapshot
+	}
+
+	ldb, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true})
+	if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+		return nil, errDBLocked
+	}
+
+	return ldb, err
+}
+
+// refreshSnapshot replaces the files in dir with the current files of the
+// db. Table files never change once written, so they are hard linked when
+// possible, the manifest and journal are copied.
+func refreshSnapshot(path string, dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	entries, err := filepath.Glob(filepath.Join(path, "*"))
+	if err != nil {
+		return err
+	}
+	for _, src := range entries {
+		name := filepath.Base(src)
+		if name == "LOCK" || strings.HasPrefix(name, "LOG") {
+			continue
+		}
+
+		dst := filepath.Join(dir, name)
+		if strings.HasSuffix(name, ".ldb") || strings.HasSuffix(name, ".sst") {
+			if err := os.Link(src, dst); err == nil {
+				continue
+			}
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func (f *follower) poll(path string, snapshotDir string) error {
+	ldb, err := openFollowStore(path, snapshotDir)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+
+	st := &readOnlyStore{ldb: ldb}
+	if !f.loaded {
+		if err := loadSchema(st); err != nil {
+			return err
+		}
+		f.loaded = true
+	}
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+
+	if !f.started {
+		// start after the current tip
+		f.started = true
+		f.next = current + 1
+		hash, err := getBlockHash(st, current)
+		if err != nil {
+			return err
+		}
+		f.hashes[current] = hash
+		return nil
+	}
+
+	if err := f.checkFork(st, current); err != nil {
+		return err
+	}
+
+	return f.emitBlocks(st, f.next, current)
+}
+
+// checkFork emits a rollback event when the tip moved below the last
+// emitted block or one of the remembered hashes changed.
+func (f *follower) checkFork(st store, current uint32) error {
+	if f.next == 0 {
+		return nil
+	}
+
+	// nothing emitted yet
+	last := f.next - 1
+	if _, ok := f.hashes[last]; !ok {
+		return nil
+	}
+
+	fork := last
+	if current < fork {
+		fork = current
+	}
+	for fork > 0 {
+		known, ok := f.hashes[fork]
+		if !ok {
+			break
+		}
+		if hash, err := getBlockHash(st, fork); err == nil && hash == known {
+			break
+		}
+		fork--
+	}
+
+	if fork == last {
+		return nil
+	}
+
+	if err := f.out.Encode(followEvent{Type: "rollback", Height: fork, FromHeight: last}); err != nil {
+		return err
+	}
+	for h := fork + 1; h <= last; h++ {
+		delete(f.hashes, h)
+	}
+	f.next = fork + 1
+
+	return nil
+}
+
+func (f *follower) emitBlocks(st store, from uint32, to uint32) error {
+	for height := from; height <= to; height++ {
+		hash, err := getBlockHash(st, height)
+		if err != nil {
+			return err
+		}
+
+		event := followEvent{Type: "block", Height: height, Hash: hashString(hash)}
+		if f.blocks {
+			b, err := getBlockByHash(st, hash)
+			if err != nil {
+				return err
+			}
+			data, err := b.MarshalJson()
+			if err != nil {
+				return err
+			}
+			event.Block = data
+		}
+
+		if err := f.out.Encode(event); err != nil {
+			return err
+		}
+		f.hashes[height] = hash
+		f.next = height + 1
+		delete(f.hashes, height-followHistory)
+	}
+
+	return nil
+}
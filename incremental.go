@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/core/transaction/payload"
+	"github.com/nknorg/nkn/db"
+)
+
+// stateItems are the items changed by the transactions of a block, an
+// incremental export writes the entries the exported blocks touched.
+var stateItems = []string{"asset", "issued", "prepaid", "unspent", "utxo"}
+
+// touchedKeys maps the db keys changed by a range of blocks to their item.
+type touchedKeys map[string]string
+
+// addTransaction records the state entries txn changed when its block was
+// added: the unspent index and utxos of its outputs and of the outputs it
+// spends, the prepaid balance of Prepaid and Withdraw, and the asset and
+// issued entries of RegisterAsset and IssueAsset.
+func (t touchedKeys) addTransaction(st store, txn *tx.Transaction, height uint32) error {
+	txHash := txn.Hash()
+	if len(txn.Outputs) > 0 {
+		t[string(append([]byte{byte(db.IX_Unspent)}, txHash.ToArray()...))] = "unspent"
+	}
+	for _, output := range txn.Outputs {
+		t.addUTXO(output, height)
+	}
+
+	for _, input := range txn.Inputs {
+		refer, referHeight, err := getTransaction(st, input.ReferTxID)
+		if err != nil {
+			return fmt.Errorf("input of %s refers to the missing transaction %s", hashString(txHash), hashString(input.ReferTxID))
+		}
+		if int(input.ReferTxOutputIndex) >= len(refer.Outputs) {
+			return fmt.Errorf("input of %s refers to a missing output of %s", hashString(txHash), hashString(input.ReferTxID))
+		}
+		t[string(append([]byte{byte(db.IX_Unspent)}, input.ReferTxID.ToArray()...))] = "unspent"
+		t.addUTXO(refer.Outputs[input.ReferTxOutputIndex], referHeight)
+
+		if txn.TxType == tx.Prepaid {
+			programHash := refer.Outputs[input.ReferTxOutputIndex].ProgramHash
+			t[string(append([]byte{byte(db.ST_Prepaid)}, programHash.ToArray()...))] = "prepaid"
+		}
+	}
+
+	switch txn.TxType {
+	case tx.Withdraw:
+		if withdrawPld, ok := txn.Payload.(*payload.Withdraw); ok {
+			t[string(append([]byte{byte(db.ST_Prepaid)}, withdrawPld.ProgramHash.ToArray()...))] = "prepaid"
+		}
+	case tx.RegisterAsset:
+		t[string(append([]byte{byte(db.ST_Info)}, txHash.ToArray()...))] = "asset"
+	case tx.IssueAsset:
+		for assetID := range txn.GetMergedAssetIDValueFromOutputs() {
+			t[string(append([]byte{byte(db.ST_QuantityIssued)}, assetID.ToArray()...))] = "issued"
+		}
+	}
+
+	return nil
+}
+
+func (t touchedKeys) addUTXO(output *tx.TxnOutput, height uint32) {
+	key := append([]byte{byte(db.IX_Unspent_UTXO)}, output.ProgramHash.ToArray()...)
+	key = append(key, output.AssetID.ToArray()...)
+	t[string(append(key, heightBytes(height)...))] = "utxo"
+}
+
+// deletedLine formats an entry that no longer exists in the db.
+func deletedLine(key []byte, israw bool) string {
+	if israw {
+		return hex.EncodeToString(key) + ",deleted\n"
+	}
+
+	data, _ := json.Marshal(struct {
+		Key     string `json:"key"`
+		Deleted bool   `json:"deleted"`
+	}{hex.EncodeToString(key), true})
+	return string(data) + "\n"
+}
+
+// exportTouched writes the current value of every touched entry of item in
+// key order, entries that were deleted are written as deletions.
+func exportTouched(st store, touched touchedKeys, item string, w recordWriter, israw bool) error {
+	keys := make([]string, 0)
+	for k, i := range touched {
+		if i == item {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := []byte(k)
+		line := deletedLine(key, israw)
+		if value, err := st.Get(key); err == nil {
+			line = exportLine(st, item, key, value, israw)
+		}
+		if err := w.writeRecord(key, line); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// exportState is the state file of incremental exports, it records the last
+// exported block.
+type exportState struct {
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+	Time   string `json:"time"`
+}
+
+func loadExportState(path string) (*exportState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &exportState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+
+	return state, nil
+}
+
+// saveExportState replaces the state file through a rename, so that an
+// interrupted run keeps the previous state.
+func saveExportState(path string, state *exportState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// exportIncremental exports the blocks and transactions from since up to the
+// current height, and the current value of the state entries those blocks
+// changed. A negative since continues after the block recorded in statePath.
+func exportIncremental(st *db.LevelDBStore, since int, statePath string, israw bool, opts outputOptions) error {
+	var state *exportState
+	if statePath != "" {
+		var err error
+		if state, err = loadExportState(statePath); err != nil {
+			return err
+		}
+	}
+
+	if since < 0 {
+		since = 0
+		if state != nil {
+			hash, err := getBlockHash(st, state.Height)
+			if err != nil || hashString(hash) != state.Hash {
+				return fmt.Errorf("reorg detected: block %s at height %d is no longer on the main chain, rerun with --since-height below the fork", state.Hash, state.Height)
+			}
+			since = int(state.Height) + 1
+		}
+	}
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+	if uint32(since) > current {
+		fmt.Printf("nothing to export, height %d is above the current height %d\n", since, current)
+		return nil
+	}
+
+	from, to := uint32(since), current
+	suffix := fmt.Sprintf("%d-%d.txt", from, to)
+	blocks := newChunkWriter("block_"+suffix, "block", opts)
+	txs := newChunkWriter("transaction_"+suffix, "transaction", opts)
+
+	touched := make(touchedKeys)
+	p := newProgress(int(to-from)+1, to, 5*time.Second)
+	for height := from; height <= to; height++ {
+		if err := exportBlockRange(st, height, blocks, txs, touched, israw); err != nil {
+			blocks.Close()
+			txs.Close()
+			return fmt.Errorf("export block %d: %v", height, err)
+		}
+		p.update(height)
+	}
+	if err := blocks.Close(); err != nil {
+		return err
+	}
+	if err := txs.Close(); err != nil {
+		return err
+	}
+
+	for _, item := range stateItems {
+		if err := exportTouched(st, touched, item, newChunkWriter(item+"_"+suffix, item, opts), israw); err != nil {
+			return err
+		}
+	}
+
+	hash, err := getBlockHash(st, to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported blocks %d to %d\n", from, to)
+
+	if statePath == "" {
+		return nil
+	}
+
+	return saveExportState(statePath, &exportState{
+		Height: to,
+		Hash:   hashString(hash),
+		Time:   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// exportBlockRange writes the block at height and its stored transactions,
+// and records the state entries they changed in touched.
+func exportBlockRange(st store, height uint32, blocks, txs recordWriter, touched touchedKeys, israw bool) error {
+	hash, err := getBlockHash(st, height)
+	if err != nil {
+		return err
+	}
+
+	key := append([]byte{byte(db.DATA_Header)}, hash.ToArray()...)
+	data, err := st.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := blocks.writeRecord(key, exportLine(st, "block", key, data, israw)); err != nil {
+		return err
+	}
+
+	b, err := parseTrimmedBlock(data)
+	if err != nil {
+		return err
+	}
+	for _, txn := range b.Transactions {
+		txHash := txn.Hash()
+		txKey := append([]byte{byte(db.DATA_Transaction)}, txHash.ToArray()...)
+		value, err := st.Get(txKey)
+		if err != nil {
+			return err
+		}
+		if err := txs.writeRecord(txKey, exportLine(st, "transaction", txKey, value, israw)); err != nil {
+			return err
+		}
+
+		_, full, err := parseTransaction(value)
+		if err != nil {
+			return err
+		}
+		if err := touched.addTransaction(st, full, height); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
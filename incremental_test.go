@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	tx "github.com/nknorg/nkn/core/transaction"
+)
+
+type linesWriter struct {
+	lines []string
+}
+
+func (w *linesWriter) writeRecord(key []byte, line string) error {
+	w.lines = append(w.lines, line)
+	return nil
+}
+
+func (w *linesWriter) Close() error {
+	return nil
+}
+
+func TestExportTouchedDeletions(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 100))
+	c.addBlock(t, a)
+	spend := testTransfer([]*tx.TxnInput{testInput(a, 0)}, testOutput(2, 100))
+	c.addBlock(t, spend)
+
+	blocks, txs := &linesWriter{}, &linesWriter{}
+	touched := make(touchedKeys)
+	if err := exportBlockRange(c.st, 1, blocks, txs, touched, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks.lines) != 1 || len(txs.lines) != 1 {
+		t.Fatalf("%d blocks and %d transactions exported", len(blocks.lines), len(txs.lines))
+	}
+
+	utxos := &linesWriter{}
+	if err := exportTouched(c.st, touched, "utxo", utxos, true); err != nil {
+		t.Fatal(err)
+	}
+	spentKey := hex.EncodeToString(testUTXOKey(a.Outputs[0], 0))
+	newKey := hex.EncodeToString(testUTXOKey(spend.Outputs[0], 1))
+	if len(utxos.lines) != 2 || !contains(utxos.lines, spentKey+",deleted\n") {
+		t.Fatalf("utxo lines %q, want the deletion of %s", utxos.lines, spentKey)
+	}
+	if !strings.HasPrefix(utxos.lines[0], newKey) && !strings.HasPrefix(utxos.lines[1], newKey) {
+		t.Fatalf("utxo lines %q, want the new utxo %s", utxos.lines, newKey)
+	}
+
+	unspent := &linesWriter{}
+	if err := exportTouched(c.st, touched, "unspent", unspent, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(unspent.lines) != 2 || !contains(unspent.lines, `{"key":"90`+hex.EncodeToString(a.Hash().ToArray())+`","deleted":true}`+"\n") {
+		t.Fatalf("unspent lines %q", unspent.lines)
+	}
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
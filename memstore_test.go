@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/common/serialization"
+	"github.com/nknorg/nkn/core/contract/program"
+	"github.com/nknorg/nkn/core/ledger"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/core/transaction/payload"
+	"github.com/nknorg/nkn/db"
+)
+
+// memStore is a map backed store for tests. Like LevelDBStore, batch writes
+// are only visible after BatchCommit.
+type memStore struct {
+	data  map[string][]byte
+	batch map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (m *memStore) Get(key []byte) ([]byte, error) {
+	value, ok := m.data[string(key)]
+	if !ok {
+		return nil, errOverlayNotFound
+	}
+	return value, nil
+}
+
+func (m *memStore) NewIterator(prefix []byte) db.IIterator {
+	items := make(map[string][]byte)
+	for k, v := range m.data {
+		if strings.HasPrefix(k, string(prefix)) {
+			items[k] = v
+		}
+	}
+	return newSliceIterator(items)
+}
+
+func (m *memStore) NewBatch() error {
+	m.batch = make(map[string][]byte)
+	return nil
+}
+
+func (m *memStore) BatchPut(key []byte, value []byte) error {
+	m.batch[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// BatchDelete stages a delete as a nil value.
+func (m *memStore) BatchDelete(key []byte) error {
+	m.batch[string(key)] = nil
+	return nil
+}
+
+func (m *memStore) BatchCommit() error {
+	for k, v := range m.batch {
+		if v == nil {
+			delete(m.data, k)
+		} else {
+			m.data[k] = v
+		}
+	}
+	m.batch = nil
+	return nil
+}
+
+func (m *memStore) put(key []byte, value []byte) {
+	m.data[string(key)] = value
+}
+
+func (m *memStore) clone() *memStore {
+	c := newMemStore()
+	for k, v := range m.data {
+		c.data[k] = append([]byte{}, v...)
+	}
+	return c
+}
+
+// items returns the entries under prefix.
+func (m *memStore) items(prefix db.DataEntryPrefix) map[string]string {
+	items := make(map[string]string)
+	for k, v := range m.data {
+		if k[0] == byte(prefix) {
+			items[k] = string(v)
+		}
+	}
+	return items
+}
+
+var testAsset = common.Uint256{0xaa}
+
+func testProgramHash(b byte) common.Uint160 {
+	return common.Uint160{b}
+}
+
+func testOutput(programHash byte, value int64) *tx.TxnOutput {
+	return &tx.TxnOutput{AssetID: testAsset, Value: common.Fixed64(value), ProgramHash: testProgramHash(programHash)}
+}
+
+func testInput(refer *tx.Transaction, index uint16) *tx.TxnInput {
+	return &tx.TxnInput{ReferTxID: refer.Hash(), ReferTxOutputIndex: index}
+}
+
+func testTransfer(inputs []*tx.TxnInput, outputs ...*tx.TxnOutput) *tx.Transaction {
+	return &tx.Transaction{
+		TxType:   tx.TransferAsset,
+		Payload:  &payload.TransferAsset{},
+		Inputs:   inputs,
+		Outputs:  outputs,
+		Programs: []*program.Program{},
+	}
+}
+
+// testChain writes blocks into a memStore the way a node persists them, so
+// that rollback can be compared with the state before a block.
+type testChain struct {
+	st     *memStore
+	blocks []*ledger.Block
+}
+
+func newTestChain() *testChain {
+	st := newMemStore()
+	st.put([]byte{byte(db.CFG_Version)}, []byte{0x01})
+	return &testChain{st: st}
+}
+
+func (c *testChain) addBlock(t *testing.T, txns ...*tx.Transaction) *ledger.Block {
+	height := uint32(len(c.blocks))
+	h := &ledger.Header{
+		Height:    height,
+		Timestamp: int64(height),
+		Program:   &program.Program{Code: []byte{}, Parameter: []byte{}},
+	}
+	if height > 0 {
+		h.PrevBlockHash = c.blocks[height-1].Hash()
+	}
+	b := &ledger.Block{Header: h, Transactions: txns}
+	hash := b.Hash()
+
+	value := bytes.NewBuffer(make([]byte, schema.headerPrefixSize))
+	if err := b.Trim(value); err != nil {
+		t.Fatal(err)
+	}
+	c.st.put(append([]byte{byte(db.DATA_Header)}, hash.ToArray()...), value.Bytes())
+	c.st.put(append([]byte{byte(db.DATA_BlockHash)}, heightBytes(height)...), hash.ToArray())
+
+	current := bytes.NewBuffer(nil)
+	hash.Serialize(current)
+	serialization.WriteUint32(current, height)
+	c.st.put([]byte{byte(db.SYS_CurrentBlock)}, current.Bytes())
+
+	for _, txn := range txns {
+		txHash := txn.Hash()
+		data := bytes.NewBuffer(heightBytes(height))
+		if err := txn.Serialize(data); err != nil {
+			t.Fatal(err)
+		}
+		c.st.put(append([]byte{byte(db.DATA_Transaction)}, txHash.ToArray()...), data.Bytes())
+
+		for _, input := range txn.Inputs {
+			refer, referHeight, err := getTransaction(c.st, input.ReferTxID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.spendUnspent(t, input)
+			output := refer.Outputs[input.ReferTxOutputIndex]
+			c.removeUTXO(t, output, referHeight, input)
+		}
+
+		index := make([]uint16, 0, len(txn.Outputs))
+		for i, output := range txn.Outputs {
+			index = append(index, uint16(i))
+			c.addUTXO(t, output, height, &tx.UTXOUnspent{Txid: txHash, Index: uint32(i), Value: output.Value})
+		}
+		if len(index) > 0 {
+			c.st.put(append([]byte{byte(db.IX_Unspent)}, txHash.ToArray()...), common.ToByteArray(index))
+		}
+	}
+
+	c.blocks = append(c.blocks, b)
+	return b
+}
+
+func (c *testChain) spendUnspent(t *testing.T, input *tx.TxnInput) {
+	key := append([]byte{byte(db.IX_Unspent)}, input.ReferTxID.ToArray()...)
+	value, err := c.st.Get(key)
+	if err != nil {
+		t.Fatalf("spend of unknown unspent index %x", input.ReferTxID.ToArray())
+	}
+	index, _ := common.GetUint16Array(value)
+
+	left := make([]uint16, 0, len(index))
+	for _, i := range index {
+		if i != input.ReferTxOutputIndex {
+			left = append(left, i)
+		}
+	}
+	if len(left) == 0 {
+		delete(c.st.data, string(key))
+		return
+	}
+	c.st.put(key, common.ToByteArray(left))
+}
+
+func testUTXOKey(output *tx.TxnOutput, height uint32) []byte {
+	key := append([]byte{byte(db.IX_Unspent_UTXO)}, output.ProgramHash.ToArray()...)
+	key = append(key, output.AssetID.ToArray()...)
+	return append(key, heightBytes(height)...)
+}
+
+func (c *testChain) utxos(t *testing.T, key []byte) []*tx.UTXOUnspent {
+	value, err := c.st.Get(key)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(value)
+	count, err := serialization.ReadVarUint(r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unspents := make([]*tx.UTXOUnspent, 0, count)
+	for i := uint64(0); i < count; i++ {
+		u := new(tx.UTXOUnspent)
+		if err := u.Deserialize(r); err != nil {
+			t.Fatal(err)
+		}
+		unspents = append(unspents, u)
+	}
+	return unspents
+}
+
+func (c *testChain) putUTXOs(key []byte, unspents []*tx.UTXOUnspent) {
+	if len(unspents) == 0 {
+		delete(c.st.data, string(key))
+		return
+	}
+	w := bytes.NewBuffer(nil)
+	serialization.WriteVarUint(w, uint64(len(unspents)))
+	for _, u := range unspents {
+		u.Serialize(w)
+	}
+	c.st.put(key, w.Bytes())
+}
+
+func (c *testChain) addUTXO(t *testing.T, output *tx.TxnOutput, height uint32, u *tx.UTXOUnspent) {
+	key := testUTXOKey(output, height)
+	c.putUTXOs(key, append(c.utxos(t, key), u))
+}
+
+func (c *testChain) removeUTXO(t *testing.T, output *tx.TxnOutput, height uint32, input *tx.TxnInput) {
+	key := testUTXOKey(output, height)
+	left := make([]*tx.UTXOUnspent, 0)
+	for _, u := range c.utxos(t, key) {
+		if u.Txid != input.ReferTxID || u.Index != uint32(input.ReferTxOutputIndex) {
+			left = append(left, u)
+		}
+	}
+	c.putUTXOs(key, left)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+// metricsSampler scans the db periodically and keeps the metrics of the last
+// successful scan rendered in the Prometheus text format. Every scan opens
+// the db read only, or a snapshot of it, so a running node is not blocked.
+type metricsSampler struct {
+	path        string
+	snapshotDir string
+	interval    time.Duration
+
+	sync.RWMutex
+	scan     []byte
+	lastScan time.Time
+	duration time.Duration
+	success  bool
+}
+
+func NewMetricsCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "metrics",
+		Usage:       "export db health metrics for prometheus",
+		Description: "periodically sample the db and serve chain height, key counts and sizes per prefix, issued and prepaid totals on /metrics",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "listen, l",
+				Usage: "the address to listen on",
+				Value: "127.0.0.1:9102",
+			},
+			cli.IntFlag{
+				Name:  "interval",
+				Usage: "seconds between two scans of the db",
+				Value: 60,
+			},
+			cli.StringFlag{
+				Name:  "snapshot-dir",
+				Usage: "scan a snapshot refreshed in this directory instead of opening the db read only, needed while a node is running on the db",
+			},
+		},
+		Action: metricsAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func metricsAction(c *cli.Context) error {
+	interval := c.Int("interval")
+	if interval <= 0 {
+		return cli.NewExitError("interval must be positive", 1)
+	}
+
+	m := &metricsSampler{
+		path:        c.GlobalString("path"),
+		snapshotDir: c.String("snapshot-dir"),
+		interval:    time.Duration(interval) * time.Second,
+	}
+	if err := m.sample(); err == errDBLocked {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	go m.run()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveHTTP)
+
+	listen := c.String("listen")
+	fmt.Println("serving metrics of db", c.GlobalString("path"), "on", listen+"/metrics")
+	return http.ListenAndServe(listen, mux)
+}
+
+func (m *metricsSampler) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.sample()
+	}
+}
+
+func (m *metricsSampler) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	success := 0.0
+	if m.success {
+		success = 1
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(m.scan)
+	writeMetric(buf, "dbtool_scan_success", "gauge", "whether the last db scan succeeded", "", success)
+	writeMetric(buf, "dbtool_scan_duration_seconds", "gauge", "duration of the last db scan", "", m.duration.Seconds())
+	writeMetric(buf, "dbtool_last_scan_timestamp_seconds", "gauge", "unix time of the last successful db scan", "", float64(m.lastScan.Unix()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// sample scans the db. A failed scan keeps the previous metrics and only
+// reports dbtool_scan_success 0.
+func (m *metricsSampler) sample() error {
+	start := time.Now()
+	scan, err := m.scanStore()
+	duration := time.Since(start)
+	if err != nil {
+		fmt.Println("metrics scan err:", err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.duration = duration
+	m.success = err == nil
+	if err == nil {
+		m.scan = scan
+		m.lastScan = start
+	}
+
+	return err
+}
+
+func (m *metricsSampler) scanStore() ([]byte, error) {
+	ldb, err := openFollowStore(m.path, m.snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	defer ldb.Close()
+
+	st := &readOnlyStore{ldb: ldb}
+	if err := loadSchema(st); err != nil {
+		return nil, err
+	}
+
+	return renderMetrics(st)
+}
+
+func writeMetric(buf *bytes.Buffer, name string, typ string, help string, labels string, value float64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(buf, "%s%s %v\n", name, labels, value)
+}
+
+func renderMetrics(st store) ([]byte, error) {
+	stats, err := getStats(st)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeMetric(buf, "dbtool_chain_height", "gauge", "height of the current block", "", float64(stats.Height))
+	writeMetric(buf, "dbtool_db_keys", "gauge", "number of keys in the db", "", float64(stats.Keys))
+	writeMetric(buf, "dbtool_db_bytes", "gauge", "bytes of keys and values in the db", "", float64(stats.Bytes))
+
+	series := []struct {
+		name  string
+		help  string
+		value func(ps *prefixStats) uint64
+	}{
+		{"dbtool_prefix_keys", "number of keys per prefix", func(ps *prefixStats) uint64 { return ps.Keys }},
+		{"dbtool_prefix_key_bytes", "bytes of keys per prefix", func(ps *prefixStats) uint64 { return ps.KeyBytes }},
+		{"dbtool_prefix_value_bytes", "bytes of values per prefix", func(ps *prefixStats) uint64 { return ps.ValueBytes }},
+	}
+	for _, s := range series {
+		fmt.Fprintf(buf, "# HELP %s %s\n", s.name, s.help)
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", s.name)
+		for _, ps := range stats.Prefixes {
+			fmt.Fprintf(buf, "%s{prefix=\"%s\",name=\"%s\"} %d\n", s.name, ps.Prefix, ps.Name, s.value(ps))
+		}
+	}
+
+	// ST_QuantityIssued is iterated in asset id order
+	fmt.Fprintf(buf, "# HELP dbtool_asset_issued total issued quantity per asset in Fixed64 units\n")
+	fmt.Fprintf(buf, "# TYPE dbtool_asset_issued gauge\n")
+	iter := st.NewIterator([]byte{byte(db.ST_QuantityIssued)})
+	for iter.Next() {
+		amount, err := parseIssued(iter.Value())
+		if err != nil {
+			iter.Release()
+			return nil, fmt.Errorf("issued %x: %v", iter.Key()[1:], err)
+		}
+		fmt.Fprintf(buf, "dbtool_asset_issued{asset=\"%x\"} %d\n", iter.Key()[1:], int64(amount))
+	}
+	iter.Release()
+
+	var prepaidTotal common.Fixed64
+	prepaidAccounts := 0
+	iter = st.NewIterator([]byte{byte(db.ST_Prepaid)})
+	for iter.Next() {
+		amount, _, err := parsePrepaid(iter.Value())
+		if err != nil {
+			iter.Release()
+			return nil, fmt.Errorf("prepaid %x: %v", iter.Key()[1:], err)
+		}
+		prepaidTotal += amount
+		prepaidAccounts++
+	}
+	iter.Release()
+
+	writeMetric(buf, "dbtool_prepaid_total", "gauge", "total prepaid amount in Fixed64 units", "", float64(prepaidTotal))
+	writeMetric(buf, "dbtool_prepaid_accounts", "gauge", "number of program hashes with a prepaid entry", "", float64(prepaidAccounts))
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+const migrateBatchSize = 10000
+
+// migrationMarkerKey stores the progress of a running migration so that an
+// interrupted migrate resumes where it stopped.
+var migrationMarkerKey = append([]byte{dbtoolPrefix}, []byte("migration")...)
+
+// migration rewrites the values of a db from one version to the next.
+type migration struct {
+	from  string
+	to    string
+	steps []migrationStep
+}
+
+// migrationStep rewrites every value under one prefix.
+type migrationStep struct {
+	prefix  db.DataEntryPrefix
+	rewrite func(key []byte, value []byte) ([]byte, error)
+}
+
+type migrationMarker struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Step    int    `json:"step"`
+	LastKey string `json:"lastKey"`
+}
+
+// migrations lists the known layout changes between db versions. A new
+// version adds its entry here and its profile to schemaProfiles.
+var migrations = []*migration{}
+
+func NewMigrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "migrate",
+		Usage:       "migrate db values between versions",
+		Description: "rewrite db values in place to the layout of another db version, an interrupted migration resumes on the next run",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "to, t",
+				Usage: "the target db version, hex string",
+			},
+		},
+		Action: migrateAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func migrateAction(c *cli.Context) error {
+	to := c.String("to")
+	if to == "" {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if _, ok := schemaProfiles[to]; !ok {
+		return cli.NewExitError("unknown target version "+to, 1)
+	}
+
+	from := schema.version
+	if from == to {
+		fmt.Println("db is already at version", to)
+		return nil
+	}
+
+	path, err := findMigrationPath(from, to)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	marker, err := getMigrationMarker(st)
+	if err != nil {
+		return err
+	}
+	if marker != nil && (marker.From != from || marker.To != path[0].to) {
+		return cli.NewExitError(fmt.Sprintf("found migration marker from %s to %s, but db is at version %s", marker.From, marker.To, from), 1)
+	}
+
+	for _, m := range path {
+		if err := runMigration(st, m, marker); err != nil {
+			return err
+		}
+		marker = nil
+		fmt.Printf("migrated db from version %s to %s\n", m.from, m.to)
+	}
+
+	return nil
+}
+
+// findMigrationPath chains migrations from version from to version to.
+func findMigrationPath(from string, to string) ([]*migration, error) {
+	path := make([]*migration, 0)
+	seen := map[string]bool{from: true}
+	current := from
+	for current != to {
+		var next *migration
+		for _, m := range migrations {
+			if m.from == current {
+				next = m
+				break
+			}
+		}
+		if next == nil || seen[next.to] {
+			return nil, fmt.Errorf("no migration path from version %s to %s", from, to)
+		}
+
+		path = append(path, next)
+		seen[next.to] = true
+		current = next.to
+	}
+
+	return path, nil
+}
+
+func getMigrationMarker(st store) (*migrationMarker, error) {
+	value, err := st.Get(migrationMarkerKey)
+	if err != nil {
+		return nil, nil
+	}
+
+	marker := new(migrationMarker)
+	if err := json.Unmarshal(value, marker); err != nil {
+		return nil, err
+	}
+
+	return marker, nil
+}
+
+func putMigrationMarker(st store, marker *migrationMarker) error {
+	value, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+
+	return st.BatchPut(migrationMarkerKey, value)
+}
+
+// runMigration applies every step of m in batches. Each batch also records
+// the last rewritten key in the marker, the last batch switches CFG_Version
+// and removes the marker.
+func runMigration(st store, m *migration, marker *migrationMarker) error {
+	if marker == nil {
+		marker = &migrationMarker{From: m.from, To: m.to}
+	} else {
+		fmt.Printf("resume migration from %s to %s at step %d, key %s\n", marker.From, marker.To, marker.Step, marker.LastKey)
+	}
+
+	for ; marker.Step < len(m.steps); marker.Step++ {
+		step := m.steps[marker.Step]
+		if err := runMigrationStep(st, step, marker); err != nil {
+			return err
+		}
+		marker.LastKey = ""
+	}
+
+	version, err := hex.DecodeString(m.to)
+	if err != nil {
+		return err
+	}
+
+	if err := st.NewBatch(); err != nil {
+		return err
+	}
+	if err := st.BatchPut([]byte{byte(db.CFG_Version)}, version); err != nil {
+		return err
+	}
+	if err := st.BatchDelete(migrationMarkerKey); err != nil {
+		return err
+	}
+	if err := st.BatchCommit(); err != nil {
+		return err
+	}
+
+	schema = schemaProfiles[m.to]
+	return nil
+}
+
+func runMigrationStep(st store, step migrationStep, marker *migrationMarker) error {
+	prefix := []byte{byte(step.prefix)}
+	lastKey, err := hex.DecodeString(marker.LastKey)
+	if err != nil {
+		return err
+	}
+
+	iter := st.NewIterator(prefix)
+	defer iter.Release()
+
+	ok := iter.First()
+	if len(lastKey) > 0 {
+		// continue after the last key committed by the interrupted run
+		ok = iter.Seek(lastKey)
+		if ok && string(iter.Key()) == string(lastKey) {
+			ok = iter.Next()
+		}
+	}
+
+	if err := st.NewBatch(); err != nil {
+		return err
+	}
+
+	count, total := 0, 0
+	for ; ok; ok = iter.Next() {
+		key := append([]byte{}, iter.Key()...)
+		value, err := step.rewrite(key, iter.Value())
+		if err != nil {
+			return fmt.Errorf("rewrite key %x: %v", key, err)
+		}
+		if err := st.BatchPut(key, value); err != nil {
+			return err
+		}
+
+		count++
+		total++
+		marker.LastKey = hex.EncodeToString(key)
+		if count == migrateBatchSize {
+			if err := commitMigrationBatch(st, marker); err != nil {
+				return err
+			}
+			fmt.Printf("migrate prefix %02x: %d values rewritten\n", byte(step.prefix), total)
+			count = 0
+		}
+	}
+
+	if err := commitMigrationBatch(st, marker); err != nil {
+		return err
+	}
+	fmt.Printf("migrate prefix %02x done: %d values rewritten\n", byte(step.prefix), total)
+
+	return nil
+}
+
+func commitMigrationBatch(st store, marker *migrationMarker) error {
+	if err := putMigrationMarker(st, marker); err != nil {
+		return err
+	}
+	if err := st.BatchCommit(); err != nil {
+		return errors.New("commit migration batch: " + err.Error())
+	}
+
+	return st.NewBatch()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/nknorg/nkn/db"
+)
+
+// withTestMigration registers a synthetic version 7e whose DATA_Header
+// values drop the system fee, and a migration from 01 to it.
+func withTestMigration(t *testing.T) func() {
+	profile := *schemaProfiles["01"]
+	profile.version = "7e"
+	profile.headerPrefixSize = 0
+	schemaProfiles["7e"] = &profile
+
+	saved := migrations
+	migrations = []*migration{{
+		from: "01",
+		to:   "7e",
+		steps: []migrationStep{{
+			prefix: db.DATA_Header,
+			rewrite: func(key []byte, value []byte) ([]byte, error) {
+				return value[8:], nil
+			},
+		}},
+	}}
+
+	return func() {
+		delete(schemaProfiles, "7e")
+		migrations = saved
+		schema = schemaProfiles["01"]
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	defer withTestMigration(t)()
+
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(1, 100)))
+	b := c.addBlock(t, testTransfer(nil, testOutput(2, 100)))
+
+	path, err := findMigrationPath("01", "7e")
+	if err != nil || len(path) != 1 {
+		t.Fatalf("migration path %v, %v", path, err)
+	}
+	if err := runMigration(c.st, path[0], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := c.st.data[string([]byte{byte(db.CFG_Version)})]; !bytes.Equal(v, []byte{0x7e}) {
+		t.Fatalf("CFG_Version %x after migration, want 7e", v)
+	}
+	if _, err := c.st.Get(migrationMarkerKey); err == nil {
+		t.Fatal("migration marker left behind")
+	}
+	if schema.version != "7e" {
+		t.Fatalf("active schema %s, want 7e", schema.version)
+	}
+
+	got, err := getBlockByHeight(c.st, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash() != b.Hash() {
+		t.Fatalf("block 1 reads as %s after migration, want %s", hashString(got.Hash()), hashString(b.Hash()))
+	}
+}
+
+func TestMigrateResume(t *testing.T) {
+	defer withTestMigration(t)()
+
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(1, 100)))
+	c.addBlock(t, testTransfer(nil, testOutput(2, 100)))
+
+	// an interrupted run committed the first header and its marker
+	iter := c.st.NewIterator([]byte{byte(db.DATA_Header)})
+	iter.First()
+	first := append([]byte{}, iter.Key()...)
+	c.st.put(first, c.st.data[string(first)][8:])
+	iter.Release()
+
+	marker := &migrationMarker{From: "01", To: "7e", LastKey: hex.EncodeToString(first)}
+	if err := runMigration(c.st, migrations[0], marker); err != nil {
+		t.Fatal(err)
+	}
+
+	for h := uint32(0); h < 2; h++ {
+		if _, err := getBlockByHeight(c.st, h); err != nil {
+			t.Fatalf("block %d after resumed migration: %v", h, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/core/ledger"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+const orphansBatchSize = 1000
+
+type orphanTx struct {
+	hash   common.Uint256
+	height uint32
+	size   int
+}
+
+func NewOrphansCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "orphans",
+		Usage:       "list or delete headers and transactions not on the main chain",
+		Description: "follow PrevBlockHash from the current block to find the main chain, then list the DATA_Header and DATA_Transaction entries it does not reach",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "delete",
+				Usage: "delete the orphans in batches",
+			},
+		},
+		Action: orphansAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func orphansAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if c.Bool("delete") && !schema.known {
+		return cli.NewExitError(fmt.Sprintf("unknown db version %s, refuse to delete orphans", schema.version), 1)
+	}
+
+	headers, err := loadHeaders(st, nil)
+	if err != nil {
+		return err
+	}
+
+	chain, err := mainChain(st, headers)
+	if err != nil {
+		return err
+	}
+
+	headerOrphans := orphanHeaders(headers, chain)
+	txOrphans, err := findOrphanTxs(st, chain)
+	if err != nil {
+		return err
+	}
+
+	headerBytes, txBytes := 0, 0
+	for _, h := range headerOrphans {
+		headerBytes += h.size
+		fmt.Printf("orphan header hash:%s, height:%d, size:%d\n", hashString(h.hash), h.height, h.size)
+	}
+	for _, t := range txOrphans {
+		txBytes += t.size
+		fmt.Printf("orphan transaction hash:%s, height:%d, size:%d\n", hashString(t.hash), t.height, t.size)
+	}
+	fmt.Printf("%d orphan headers (%d bytes), %d orphan transactions (%d bytes)\n", len(headerOrphans), headerBytes, len(txOrphans), txBytes)
+
+	if !c.Bool("delete") {
+		return nil
+	}
+
+	keys := make([][]byte, 0, len(headerOrphans)+len(txOrphans))
+	for _, h := range headerOrphans {
+		keys = append(keys, append([]byte{byte(db.DATA_Header)}, h.hash.ToArray()...))
+	}
+	for _, t := range txOrphans {
+		keys = append(keys, append([]byte{byte(db.DATA_Transaction)}, t.hash.ToArray()...))
+	}
+
+	if err := deleteKeys(st, keys, orphansBatchSize); err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d orphan entries\n", len(keys))
+
+	return nil
+}
+
+// chainTxHashes collects the transaction hashes of every block on chain.
+func chainTxHashes(st store, chain []common.Uint256) (map[common.Uint256]bool, error) {
+	hashes := make(map[common.Uint256]bool)
+	for height, hash := range chain {
+		data, err := st.Get(append([]byte{byte(db.DATA_Header)}, hash.ToArray()...))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < schema.headerPrefixSize {
+			return nil, fmt.Errorf("invalid header value at height %d", height)
+		}
+
+		b := new(ledger.Block)
+		if err := b.FromTrimmedData(bytes.NewReader(data[schema.headerPrefixSize:])); err != nil {
+			return nil, err
+		}
+		for _, txn := range b.Transactions {
+			hashes[txn.Hash()] = true
+		}
+	}
+
+	return hashes, nil
+}
+
+// findOrphanTxs lists the DATA_Transaction entries that no block on chain
+// refers to. Pruned blocks no longer list their transactions, so kept
+// transactions below the pruned height are never reported.
+func findOrphanTxs(st store, chain []common.Uint256) ([]*orphanTx, error) {
+	canonical, err := chainTxHashes(st, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned, err := getPrunedHeight(st)
+	if err != nil {
+		pruned = 0
+	}
+
+	orphans := make([]*orphanTx, 0)
+	iter := st.NewIterator([]byte{byte(db.DATA_Transaction)})
+	defer iter.Release()
+	for iter.Next() {
+		hash, err := common.Uint256ParseFromBytes(iter.Key()[1:])
+		if err != nil {
+			return nil, err
+		}
+		if canonical[hash] {
+			continue
+		}
+
+		value := iter.Value()
+		if len(value) < 4 {
+			return nil, errors.New("invalid transaction value of " + hashString(hash))
+		}
+		height := binary.LittleEndian.Uint32(value[:4])
+		if height < pruned {
+			continue
+		}
+
+		orphans = append(orphans, &orphanTx{hash: hash, height: height, size: len(iter.Key()) + len(value)})
+	}
+
+	return orphans, nil
+}
+
+// deleteKeys deletes keys with one batch commit per batchSize keys.
+func deleteKeys(st *db.LevelDBStore, keys [][]byte, batchSize int) error {
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		if err := st.NewBatch(); err != nil {
+			return err
+		}
+		for _, key := range keys[start:end] {
+			if err := st.BatchDelete(key); err != nil {
+				return err
+			}
+		}
+		if err := st.BatchCommit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nknorg/nkn/db"
+)
+
+var errOverlayNotFound = errors.New("overlay: not found")
+
+// store is the part of db.LevelDBStore used by rollback. It lets rollback run
+// either directly against the db or against an overlayStore.
+type store interface {
+	Get(key []byte) ([]byte, error)
+	NewIterator(prefix []byte) db.IIterator
+	NewBatch() error
+	BatchPut(key []byte, value []byte) error
+	BatchDelete(key []byte) error
+	BatchCommit() error
+}
+
+// overlayStore stages puts and deletes in memory on top of another store.
+// Reads see the staged changes, and nothing reaches the db until Commit.
+type overlayStore struct {
+	st      store
+	puts    map[string][]byte
+	deletes map[string]bool
+}
+
+func newOverlayStore(st store) *overlayStore {
+	return &overlayStore{
+		st:      st,
+		puts:    make(map[string][]byte),
+		deletes: make(map[string]bool),
+	}
+}
+
+func (o *overlayStore) Get(key []byte) ([]byte, error) {
+	k := string(key)
+	if o.deletes[k] {
+		return nil, errOverlayNotFound
+	}
+	if value, ok := o.puts[k]; ok {
+		return value, nil
+	}
+
+	return o.st.Get(key)
+}
+
+// NewIterator merges the iterator of the underlying store with the staged
+// puts under prefix, the store is not read ahead.
+func (o *overlayStore) NewIterator(prefix []byte) db.IIterator {
+	staged := make(map[string][]byte)
+	hidden := make(map[string]bool)
+	for k, v := range o.puts {
+		if strings.HasPrefix(k, string(prefix)) {
+			staged[k] = v
+			hidden[k] = true
+		}
+	}
+	for k := range o.deletes {
+		if strings.HasPrefix(k, string(prefix)) {
+			hidden[k] = true
+		}
+	}
+
+	return &mergeIterator{base: o.st.NewIterator(prefix), staged: newSliceIterator(staged), hidden: hidden, pos: -1}
+}
+
+// NewBatch and BatchCommit are no-ops, all changes are kept until Commit.
+func (o *overlayStore) NewBatch() error {
+	return nil
+}
+
+func (o *overlayStore) BatchCommit() error {
+	return nil
+}
+
+func (o *overlayStore) BatchPut(key []byte, value []byte) error {
+	k := string(key)
+	delete(o.deletes, k)
+	o.puts[k] = append([]byte{}, value...)
+	return nil
+}
+
+func (o *overlayStore) BatchDelete(key []byte) error {
+	k := string(key)
+	delete(o.puts, k)
+	o.deletes[k] = true
+	return nil
+}
+
+// Commit writes all staged changes to the db in a single batch.
+func (o *overlayStore) Commit() error {
+	if err := o.st.NewBatch(); err != nil {
+		return err
+	}
+
+	for k := range o.deletes {
+		if err := o.st.BatchDelete([]byte(k)); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range o.puts {
+		if err := o.st.BatchPut([]byte(k), v); err != nil {
+			return err
+		}
+	}
+
+	if err := o.st.BatchCommit(); err != nil {
+		return err
+	}
+
+	o.puts = make(map[string][]byte)
+	o.deletes = make(map[string]bool)
+	return nil
+}
+
+// sliceIterator iterates a sorted in-memory key set and satisfies db.IIterator.
+type sliceIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func newSliceIterator(items map[string][]byte) *sliceIterator {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = items[k]
+	}
+
+	return &sliceIterator{keys: keys, values: values, pos: -1}
+}
+
+func (it *sliceIterator) valid() bool {
+	return it.pos >= 0 && it.pos < len(it.keys)
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.pos < len(it.keys) {
+		it.pos++
+	}
+	return it.valid()
+}
+
+func (it *sliceIterator) Prev() bool {
+	if it.pos >= 0 {
+		it.pos--
+	}
+	return it.valid()
+}
+
+func (it *sliceIterator) First() bool {
+	it.pos = 0
+	return it.valid()
+}
+
+func (it *sliceIterator) Last() bool {
+	it.pos = len(it.keys) - 1
+	return it.valid()
+}
+
+func (it *sliceIterator) Seek(key []byte) bool {
+	it.pos = sort.SearchStrings(it.keys, string(key))
+	return it.valid()
+}
+
+func (it *sliceIterator) Key() []byte {
+	if !it.valid() {
+		return nil
+	}
+	return []byte(it.keys[it.pos])
+}
+
+func (it *sliceIterator) Value() []byte {
+	if !it.valid() {
+		return nil
+	}
+	return it.values[it.pos]
+}
+
+func (it *sliceIterator) Release() {
+	it.keys = nil
+	it.values = nil
+}
+
+// mergeIterator walks the iterator of a store and the staged puts of an
+// overlayStore side by side and satisfies db.IIterator. Store keys that are
+// staged or deleted in the overlay are hidden.
+type mergeIterator struct {
+	base     db.IIterator
+	staged   *sliceIterator
+	hidden   map[string]bool
+	baseOk   bool
+	stagedOk bool
+
+	// pos is -1 before the first entry, 1 after the last and 0 on an entry
+	pos        int
+	reverse    bool
+	fromStaged bool
+}
+
+// pick makes the next entry in the iteration direction current.
+func (it *mergeIterator) pick() bool {
+	for it.baseOk && it.hidden[string(it.base.Key())] {
+		if it.reverse {
+			it.baseOk = it.base.Prev()
+		} else {
+			it.baseOk = it.base.Next()
+		}
+	}
+
+	it.pos = 0
+	switch {
+	case it.baseOk && it.stagedOk:
+		less := bytes.Compare(it.base.Key(), it.staged.Key()) < 0
+		it.fromStaged = less == it.reverse
+	case it.baseOk:
+		it.fromStaged = false
+	case it.stagedOk:
+		it.fromStaged = true
+	case it.reverse:
+		it.pos = -1
+	default:
+		it.pos = 1
+	}
+
+	return it.pos == 0
+}
+
+func (it *mergeIterator) First() bool {
+	it.reverse = false
+	it.baseOk, it.stagedOk = it.base.First(), it.staged.First()
+	return it.pick()
+}
+
+func (it *mergeIterator) Last() bool {
+	it.reverse = true
+	it.baseOk, it.stagedOk = it.base.Last(), it.staged.Last()
+	return it.pick()
+}
+
+func (it *mergeIterator) Seek(key []byte) bool {
+	it.reverse = false
+	it.baseOk, it.stagedOk = it.base.Seek(key), it.staged.Seek(key)
+	return it.pick()
+}
+
+func (it *mergeIterator) Next() bool {
+	switch {
+	case it.pos < 0:
+		return it.First()
+	case it.pos > 0:
+		return false
+	}
+
+	if it.reverse {
+		// move both to the first key after the current one
+		key := append([]byte{}, it.Key()...)
+		it.reverse = false
+		it.baseOk = it.base.Seek(key)
+		if it.baseOk && bytes.Equal(it.base.Key(), key) {
+			it.baseOk = it.base.Next()
+		}
+		it.stagedOk = it.staged.Seek(key)
+		if it.stagedOk && bytes.Equal(it.staged.Key(), key) {
+			it.stagedOk = it.staged.Next()
+		}
+	} else if it.fromStaged {
+		it.stagedOk = it.staged.Next()
+	} else {
+		it.baseOk = it.base.Next()
+	}
+
+	return it.pick()
+}
+
+func (it *mergeIterator) Prev() bool {
+	switch {
+	case it.pos > 0:
+		return it.Last()
+	case it.pos < 0:
+		return false
+	}
+
+	if !it.reverse {
+		// move both to the last key before the current one
+		key := append([]byte{}, it.Key()...)
+		it.reverse = true
+		if it.base.Seek(key) {
+			it.baseOk = it.base.Prev()
+		} else {
+			it.baseOk = it.base.Last()
+		}
+		if it.staged.Seek(key) {
+			it.stagedOk = it.staged.Prev()
+		} else {
+			it.stagedOk = it.staged.Last()
+		}
+	} else if it.fromStaged {
+		it.stagedOk = it.staged.Prev()
+	} else {
+		it.baseOk = it.base.Prev()
+	}
+
+	return it.pick()
+}
+
+func (it *mergeIterator) Key() []byte {
+	if it.pos != 0 {
+		return nil
+	}
+	if it.fromStaged {
+		return it.staged.Key()
+	}
+	return it.base.Key()
+}
+
+func (it *mergeIterator) Value() []byte {
+	if it.pos != 0 {
+		return nil
+	}
+	if it.fromStaged {
+		return it.staged.Value()
+	}
+	return it.base.Value()
+}
+
+func (it *mergeIterator) Release() {
+	it.base.Release()
+	it.staged.Release()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nknorg/nkn/db"
+)
+
+// overlayKeys collects key=value from the current entry of iter on, moving
+// it with step.
+func overlayKeys(iter db.IIterator, ok bool, step func() bool) []string {
+	keys := make([]string, 0)
+	for ; ok; ok = step() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	return keys
+}
+
+func TestOverlayIterator(t *testing.T) {
+	st := newMemStore()
+	for _, k := range []string{"a1", "a3", "a5", "a7", "b1"} {
+		st.put([]byte(k), []byte("db"))
+	}
+
+	ov := newOverlayStore(st)
+	ov.BatchPut([]byte("a0"), []byte("put"))
+	ov.BatchPut([]byte("a3"), []byte("put"))
+	ov.BatchPut([]byte("a4"), []byte("put"))
+	ov.BatchDelete([]byte("a5"))
+	ov.BatchPut([]byte("b0"), []byte("put"))
+
+	iter := ov.NewIterator([]byte("a"))
+	defer iter.Release()
+
+	want := []string{"a0=put", "a1=db", "a3=put", "a4=put", "a7=db"}
+	if got := overlayKeys(iter, iter.Next(), iter.Next); !reflect.DeepEqual(got, want) {
+		t.Fatalf("forward %v, want %v", got, want)
+	}
+
+	reverse := []string{"a7=db", "a4=put", "a3=put", "a1=db", "a0=put"}
+	if got := overlayKeys(iter, iter.Last(), iter.Prev); !reflect.DeepEqual(got, reverse) {
+		t.Fatalf("reverse %v, want %v", got, reverse)
+	}
+
+	if got := overlayKeys(iter, iter.Seek([]byte("a2")), iter.Next); !reflect.DeepEqual(got, want[2:]) {
+		t.Fatalf("seek %v, want %v", got, want[2:])
+	}
+
+	// change direction in the middle
+	iter.Seek([]byte("a4"))
+	if !iter.Prev() || string(iter.Key()) != "a3" {
+		t.Fatalf("prev from a4 at %q", iter.Key())
+	}
+	if !iter.Prev() || string(iter.Key()) != "a1" {
+		t.Fatalf("prev from a3 at %q", iter.Key())
+	}
+	if !iter.Next() || string(iter.Key()) != "a3" {
+		t.Fatalf("next from a1 at %q", iter.Key())
+	}
+}
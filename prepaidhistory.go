@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nknorg/nkn/common"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/core/transaction/payload"
+	"github.com/urfave/cli"
+)
+
+type prepaidEntry struct {
+	height  uint32
+	hash    common.Uint256
+	kind    string
+	amount  common.Fixed64
+	rates   common.Fixed64
+	balance common.Fixed64
+}
+
+func NewPrepaidHistoryCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "prepaid-history",
+		Usage:       "print the prepaid and withdraw ledger of a program hash",
+		Description: "scan blocks for Prepaid and Withdraw transactions of a program hash, print them with a running balance and check the final balance against ST_Prepaid",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "programhash, p",
+				Usage: "the program hash in hex or its address",
+			},
+			cli.IntFlag{
+				Name:  "from-height",
+				Usage: "the first height to scan, the balance starts at 0",
+				Value: 0,
+			},
+			cli.IntFlag{
+				Name:  "to-height",
+				Usage: "the last height to scan, the current height by default",
+				Value: -1,
+			},
+		},
+		Action: prepaidHistoryAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func prepaidHistoryAction(c *cli.Context) error {
+	if c.String("programhash") == "" {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	programHash, err := parseProgramHash(c.String("programhash"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+	from, to := c.Int("from-height"), c.Int("to-height")
+	if to < 0 || to > int(current) {
+		to = int(current)
+	}
+	if from < 0 || from > to {
+		return cli.NewExitError(fmt.Sprintf("invalid height range %d to %d", from, to), 1)
+	}
+	partial := from != 0 || to != int(current)
+	if pruned, err := getPrunedHeight(st); err == nil && uint32(from) < pruned {
+		fmt.Fprintf(os.Stderr, "warning: blocks below %d are pruned, their transactions are not listed\n", pruned)
+		partial = true
+	}
+
+	entries, err := getPrepaidHistory(st, programHash, uint32(from), uint32(to))
+	if err != nil {
+		return err
+	}
+
+	var balance common.Fixed64
+	unresolved := 0
+	for _, e := range entries {
+		balance = e.balance
+		if e.kind == "unresolved" {
+			unresolved++
+		}
+		fmt.Printf("height:%d, tx:%s, type:%s, amount:%s, rates:%s, balance:%s\n",
+			e.height, hashString(e.hash), e.kind, e.amount.String(), e.rates.String(), e.balance.String())
+	}
+	fmt.Printf("%d prepaid and withdraw transactions, balance:%s\n", len(entries), balance.String())
+
+	// the ledger only matches ST_Prepaid when it covers the whole chain
+	if partial {
+		fmt.Println("partial or pruned height range, final balance not checked")
+		return nil
+	}
+	if unresolved > 0 {
+		fmt.Printf("%d prepaid transactions spend pruned outputs and may belong to this program hash, final balance not checked\n", unresolved)
+		return nil
+	}
+
+	stored, _, err := getPrepaid(st, programHash)
+	if err != nil {
+		// ST_Prepaid entries are deleted when they reach 0
+		stored = 0
+	}
+	if stored != balance {
+		return cli.NewExitError(fmt.Sprintf("balance %s does not match ST_Prepaid %s", balance.String(), stored.String()), 1)
+	}
+	fmt.Println("balance matches ST_Prepaid")
+
+	return nil
+}
+
+// parseProgramHash accepts a program hash in hex or an address.
+func parseProgramHash(s string) (common.Uint160, error) {
+	if len(s) == 40 {
+		if data, err := hex.DecodeString(s); err == nil {
+			return common.Uint160ParseFromBytes(data)
+		}
+	}
+
+	programHash, err := common.ToScriptHash(s)
+	if err != nil {
+		return common.Uint160{}, errors.New("invalid programhash or address: " + s)
+	}
+
+	return programHash, nil
+}
+
+// getPrepaidHistory collects the Prepaid and Withdraw transactions of
+// programHash between from and to, decoded like rollbackPrepaidAndWithdraw
+// does. Within a block withdraws are listed before deposits, in the order
+// rollback handles them. The owner of a deposit is read from the outputs it
+// spends, deposits whose spent outputs were pruned are listed as unresolved
+// and leave the balance unchanged.
+func getPrepaidHistory(st store, programHash common.Uint160, from, to uint32) ([]*prepaidEntry, error) {
+	entries := make([]*prepaidEntry, 0)
+	var balance common.Fixed64
+	for height := from; height <= to; height++ {
+		b, err := getBlockByHeight(st, height)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", height, err)
+		}
+
+		for _, txn := range b.Transactions {
+			if txn.TxType != tx.Withdraw {
+				continue
+			}
+
+			withdrawPld, ok := txn.Payload.(*payload.Withdraw)
+			if !ok {
+				return nil, errors.New("transaction type error")
+			}
+			if withdrawPld.ProgramHash != programHash {
+				continue
+			}
+
+			balance -= txn.Outputs[0].Value
+			entries = append(entries, &prepaidEntry{
+				height:  height,
+				hash:    txn.Hash(),
+				kind:    "withdraw",
+				amount:  -txn.Outputs[0].Value,
+				balance: balance,
+			})
+		}
+
+		for _, txn := range b.Transactions {
+			if txn.TxType != tx.Prepaid {
+				continue
+			}
+
+			prepaidPld, ok := txn.Payload.(*payload.Prepaid)
+			if !ok {
+				return nil, errors.New("this is not Prepaid transaciton")
+			}
+
+			pHash, err := getProgramHashes(st, txn)
+			if err != nil || len(pHash) == 0 {
+				entries = append(entries, &prepaidEntry{
+					height:  height,
+					hash:    txn.Hash(),
+					kind:    "unresolved",
+					amount:  prepaidPld.Amount,
+					rates:   prepaidPld.Rates,
+					balance: balance,
+				})
+				continue
+			}
+			if pHash[0] != programHash {
+				continue
+			}
+
+			balance += prepaidPld.Amount
+			entries = append(entries, &prepaidEntry{
+				height:  height,
+				hash:    txn.Hash(),
+				kind:    "prepaid",
+				amount:  prepaidPld.Amount,
+				rates:   prepaidPld.Rates,
+				balance: balance,
+			})
+		}
+	}
+
+	return entries, nil
+}
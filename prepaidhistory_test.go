@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nknorg/nkn/core/contract/program"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/core/transaction/payload"
+	"github.com/nknorg/nkn/db"
+)
+
+func TestPrepaidHistoryUnresolved(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 100), testOutput(2, 100))
+	c.addBlock(t, a)
+
+	deposit := func(index uint16, amount int64) *tx.Transaction {
+		return &tx.Transaction{
+			TxType:   tx.Prepaid,
+			Payload:  &payload.Prepaid{Asset: testAsset, Amount: 10, Rates: 1},
+			Inputs:   []*tx.TxnInput{testInput(a, index)},
+			Outputs:  []*tx.TxnOutput{testOutput(byte(index+1), amount)},
+			Programs: []*program.Program{},
+		}
+	}
+	c.addBlock(t, deposit(0, 90))
+	c.addBlock(t, deposit(1, 90))
+
+	entries, err := getPrepaidHistory(c.st, testProgramHash(1), 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].kind != "prepaid" || entries[0].balance != 10 {
+		t.Fatalf("entries %+v, want one deposit of 10", entries)
+	}
+
+	// the spent transaction was pruned, the deposits can not be attributed
+	delete(c.st.data, string(append([]byte{byte(db.DATA_Transaction)}, a.Hash().ToArray()...)))
+	entries, err = getPrepaidHistory(c.st, testProgramHash(1), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].kind != "unresolved" || entries[1].balance != 0 {
+		t.Fatalf("entries %+v, want two unresolved deposits", entries)
+	}
+}
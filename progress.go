@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// progress reports the rate, height and ETA of a long running block loop at
+// most once per interval.
+type progress struct {
+	total    int
+	done     int
+	target   uint32
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+}
+
+func newProgress(total int, target uint32, interval time.Duration) *progress {
+	now := time.Now()
+	return &progress{
+		total:    total,
+		target:   target,
+		interval: interval,
+		start:    now,
+		last:     now,
+	}
+}
+
+func (p *progress) rate() float64 {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.done) / elapsed
+}
+
+func (p *progress) eta() time.Duration {
+	rate := p.rate()
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.total-p.done)/rate) * time.Second
+}
+
+// update records one more finished block, height is the current db height.
+func (p *progress) update(height uint32) {
+	p.done++
+	now := time.Now()
+	if now.Sub(p.last) < p.interval && p.done != p.total {
+		return
+	}
+	p.last = now
+
+	fmt.Printf("progress: %d/%d blocks, height:%d, target:%d, %.2f blocks/s, eta:%s\n",
+		p.done, p.total, height, p.target, p.rate(), p.eta())
+}
+
+// rollbackSummary is printed as one JSON line when rollback ends, so that
+// automation can resume from Height towards TargetHeight.
+type rollbackSummary struct {
+	Status       string  `json:"status"`
+	StartHeight  uint32  `json:"startHeight"`
+	Height       uint32  `json:"height"`
+	TargetHeight uint32  `json:"targetHeight"`
+	Rollbacked   int     `json:"rollbacked"`
+	Remaining    int     `json:"remaining"`
+	Elapsed      float64 `json:"elapsed"`
+	Error        string  `json:"error,omitempty"`
+}
+
+func (s *rollbackSummary) print() {
+	data, _ := json.Marshal(s)
+	fmt.Println(string(data))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/core/ledger"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+const pruneBatchBlocks = 1000
+
+// dbtoolPrefix is a key prefix not used by the node, dbtool keeps its own
+// records under it so that they never show up in the node's items.
+const dbtoolPrefix = 0xfe
+
+// prunedHeightKey stores the height below which blocks have been pruned.
+var prunedHeightKey = append([]byte{dbtoolPrefix}, []byte("pruned")...)
+
+type pruneResult struct {
+	blocks       int
+	txDeleted    int
+	txKept       int
+	txReferenced int
+	txBytes      int
+	headerBefore int
+	headerAfter  int
+}
+
+func NewPruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "prune",
+		Usage:       "prune historical transactions below a height",
+		Description: "delete fully spent transactions below a height and shrink their blocks to headers, utxo, prepaid, asset and issued state are kept",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "below-height, b",
+				Usage: "prune blocks with a height lower than this",
+				Value: 0,
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report what would be pruned",
+			},
+		},
+		Action: pruneAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func pruneAction(c *cli.Context) error {
+	below := c.Int("below-height")
+	if below <= 0 {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+	dryRun := c.Bool("dry-run")
+
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if !dryRun && !schema.known {
+		return cli.NewExitError(fmt.Sprintf("unknown db version %s, refuse to prune", schema.version), 1)
+	}
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+	if uint32(below) > current {
+		return cli.NewExitError(fmt.Sprintf("can not prune above the current height %d", current), 1)
+	}
+
+	// the genesis block is never pruned
+	start := uint32(1)
+	if pruned, err := getPrunedHeight(st); err == nil && pruned > start {
+		start = pruned
+	}
+	if start >= uint32(below) {
+		fmt.Printf("blocks below %d are already pruned\n", start)
+		return nil
+	}
+
+	keep, err := getReferencedBelow(st, uint32(below), current)
+	if err != nil {
+		return err
+	}
+
+	result := &pruneResult{}
+	p := newProgress(below-int(start), uint32(below), 5*time.Second)
+	for height := start; height < uint32(below); height += pruneBatchBlocks {
+		end := height + pruneBatchBlocks
+		if end > uint32(below) {
+			end = uint32(below)
+		}
+
+		if err := st.NewBatch(); err != nil {
+			return err
+		}
+		for h := height; h < end; h++ {
+			if err := pruneBlock(st, h, keep, result); err != nil {
+				return fmt.Errorf("prune block %d: %v", h, err)
+			}
+			p.update(h)
+		}
+		if dryRun {
+			continue
+		}
+		if err := st.BatchPut(prunedHeightKey, heightBytes(end)); err != nil {
+			return err
+		}
+		if err := st.BatchCommit(); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		fmt.Print("dry run, nothing deleted. ")
+	}
+	fmt.Printf("pruned %d blocks, deleted %d transactions (%d bytes), kept %d transactions with unspent outputs and %d spent by unpruned blocks, headers %d -> %d bytes\n",
+		result.blocks, result.txDeleted, result.txBytes, result.txKept, result.txReferenced, result.headerBefore, result.headerAfter)
+
+	return nil
+}
+
+// getReferencedBelow collects the transactions below the height below that
+// are spent by the blocks from below to current. Rolling back those blocks
+// reads them, so prune has to keep them.
+func getReferencedBelow(st store, below uint32, current uint32) (map[common.Uint256]bool, error) {
+	keep := make(map[common.Uint256]bool)
+	for height := below; height <= current; height++ {
+		b, err := getBlockByHeight(st, height)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", height, err)
+		}
+
+		for _, txn := range b.Transactions {
+			for _, input := range txn.Inputs {
+				if keep[input.ReferTxID] {
+					continue
+				}
+				value, err := st.Get(append([]byte{byte(db.DATA_Transaction)}, input.ReferTxID.ToArray()...))
+				if err != nil || len(value) < 4 {
+					continue
+				}
+				if binary.LittleEndian.Uint32(value[:4]) < below {
+					keep[input.ReferTxID] = true
+				}
+			}
+		}
+	}
+
+	return keep, nil
+}
+
+// pruneBlock deletes the spent transactions of the block at height that are
+// not in keep, and rewrites its DATA_Header value without the transaction
+// list.
+func pruneBlock(st store, height uint32, keep map[common.Uint256]bool, result *pruneResult) error {
+	hash, err := getBlockHash(st, height)
+	if err != nil {
+		return err
+	}
+
+	headerKey := append([]byte{byte(db.DATA_Header)}, hash.ToArray()...)
+	data, err := st.Get(headerKey)
+	if err != nil {
+		return err
+	}
+	if len(data) < schema.headerPrefixSize {
+		return errors.New("invalid header value")
+	}
+
+	b := new(ledger.Block)
+	if err := b.FromTrimmedData(bytes.NewReader(data[schema.headerPrefixSize:])); err != nil {
+		return err
+	}
+
+	for _, txn := range b.Transactions {
+		txHash := txn.Hash()
+		if _, err := st.Get(append([]byte{byte(db.IX_Unspent)}, txHash.ToArray()...)); err == nil {
+			result.txKept++
+			continue
+		}
+		if keep[txHash] {
+			result.txReferenced++
+			continue
+		}
+
+		txKey := append([]byte{byte(db.DATA_Transaction)}, txHash.ToArray()...)
+		value, err := st.Get(txKey)
+		if err != nil {
+			// already pruned
+			continue
+		}
+		if err := st.BatchDelete(txKey); err != nil {
+			return err
+		}
+		result.txDeleted++
+		result.txBytes += len(value)
+	}
+
+	b.Transactions = nil
+	w := bytes.NewBuffer(nil)
+	w.Write(data[:schema.headerPrefixSize])
+	if err := b.Trim(w); err != nil {
+		return err
+	}
+	if err := st.BatchPut(headerKey, w.Bytes()); err != nil {
+		return err
+	}
+
+	result.blocks++
+	result.headerBefore += len(data)
+	result.headerAfter += w.Len()
+
+	return nil
+}
+
+func heightBytes(height uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, height)
+	return buf
+}
+
+func getPrunedHeight(st store) (uint32, error) {
+	value, err := st.Get(prunedHeightKey)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 4 {
+		return 0, errors.New("invalid pruned height")
+	}
+
+	return binary.LittleEndian.Uint32(value), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/db"
+)
+
+func TestPruneKeepsTransactionsSpentAbove(t *testing.T) {
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(9, 1)))
+	a := testTransfer(nil, testOutput(1, 100))
+	spentBelow := testTransfer(nil, testOutput(2, 50))
+	c.addBlock(t, a, spentBelow)
+	c.addBlock(t, testTransfer([]*tx.TxnInput{testInput(spentBelow, 0)}, testOutput(3, 50)))
+	c.addBlock(t, testTransfer([]*tx.TxnInput{testInput(a, 0)}, testOutput(4, 100)))
+
+	keep, err := getReferencedBelow(c.st, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.st.NewBatch(); err != nil {
+		t.Fatal(err)
+	}
+	result := &pruneResult{}
+	for h := uint32(1); h < 3; h++ {
+		if err := pruneBlock(c.st, h, keep, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.st.BatchPut(prunedHeightKey, heightBytes(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.st.BatchCommit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.txReferenced != 1 {
+		t.Fatalf("kept %d referenced transactions, want 1", result.txReferenced)
+	}
+	if _, err := c.st.Get(append([]byte{byte(db.DATA_Transaction)}, spentBelow.Hash().ToArray()...)); err == nil {
+		t.Fatal("transaction spent below the pruned height was kept")
+	}
+
+	if err := checkRollbackInputs(c.st, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rollback(c.st); err != nil {
+		t.Fatalf("rollback above the pruned height: %v", err)
+	}
+	if len(c.st.items(db.CFG_Version)) != 1 {
+		t.Fatal("prune wrote under the CFG_Version prefix")
+	}
+}
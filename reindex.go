@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/common/serialization"
+	"github.com/nknorg/nkn/core/ledger"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+// headerHashListCount is the number of hashes nkn stores in one
+// IX_HeaderHashList entry, only full entries are stored.
+const headerHashListCount = 2000
+
+type headerInfo struct {
+	hash   common.Uint256
+	prev   common.Uint256
+	height uint32
+	size   int
+}
+
+func NewReindexCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "reindex",
+		Usage:       "rebuild the block hash index and header hash list from headers",
+		Description: "link every DATA_Header by PrevBlockHash into the chain ending at the current block, rewrite DATA_BlockHash and IX_HeaderHashList from it and report orphaned headers",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the chain and orphans",
+			},
+		},
+		Action: reindexAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func reindexAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if !c.Bool("dry-run") && !schema.known {
+		return cli.NewExitError(fmt.Sprintf("unknown db version %s, refuse to reindex", schema.version), 1)
+	}
+
+	headers, err := loadHeaders(st, nil)
+	if err != nil {
+		return err
+	}
+
+	chain, err := mainChain(st, headers)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("found %d headers, main chain has %d blocks\n", len(headers), len(chain))
+
+	for _, h := range orphanHeaders(headers, chain) {
+		fmt.Printf("orphan header hash:%s, height:%d\n", hashString(h.hash), h.height)
+	}
+
+	if c.Bool("dry-run") {
+		return nil
+	}
+
+	if err := rewriteChainIndex(st, chain); err != nil {
+		return err
+	}
+	fmt.Printf("rewrote %d block hashes and %d header hash lists\n", len(chain), len(chain)/headerHashListCount)
+
+	return nil
+}
+
+// loadHeaders reads every DATA_Header entry keyed by the hash in its key.
+// Entries that can not be read are passed to skip and left out, with a nil
+// skip the first one is returned as an error.
+func loadHeaders(st store, skip func(key []byte, err error)) (map[common.Uint256]*headerInfo, error) {
+	headers := make(map[common.Uint256]*headerInfo)
+	iter := st.NewIterator([]byte{byte(db.DATA_Header)})
+	defer iter.Release()
+	for iter.Next() {
+		h, err := readHeaderInfo(iter.Key(), iter.Value())
+		if err != nil {
+			if skip == nil {
+				return nil, err
+			}
+			skip(iter.Key(), err)
+			continue
+		}
+		headers[h.hash] = h
+	}
+
+	return headers, nil
+}
+
+func readHeaderInfo(key []byte, value []byte) (*headerInfo, error) {
+	hash, err := common.Uint256ParseFromBytes(key[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) < schema.headerPrefixSize {
+		return nil, fmt.Errorf("invalid header value of %s", hashString(hash))
+	}
+
+	h := new(ledger.Header)
+	if err := h.Deserialize(bytes.NewReader(value[schema.headerPrefixSize:])); err != nil {
+		return nil, fmt.Errorf("deserialize header %s: %v", hashString(hash), err)
+	}
+
+	return &headerInfo{
+		hash:   hash,
+		prev:   h.PrevBlockHash,
+		height: h.Height,
+		size:   len(key) + len(value),
+	}, nil
+}
+
+// mainChain follows PrevBlockHash from SYS_CurrentBlock down to the genesis
+// block, the result is indexed by height.
+func mainChain(st store, headers map[common.Uint256]*headerInfo) ([]common.Uint256, error) {
+	data, err := st.Get([]byte{byte(db.SYS_CurrentBlock)})
+	if err != nil {
+		return nil, err
+	}
+	var current common.Uint256
+	if err := current.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	h, ok := headers[current]
+	if !ok {
+		return nil, errors.New("header of the current block is missing")
+	}
+
+	chain := make([]common.Uint256, h.height+1)
+	for {
+		chain[h.height] = h.hash
+		if h.height == 0 {
+			break
+		}
+
+		prev, ok := headers[h.prev]
+		if !ok {
+			return nil, fmt.Errorf("header %s of height %d is missing", hashString(h.prev), h.height-1)
+		}
+		if prev.height != h.height-1 {
+			return nil, fmt.Errorf("header %s has height %d, expected %d", hashString(prev.hash), prev.height, h.height-1)
+		}
+		h = prev
+	}
+
+	return chain, nil
+}
+
+// orphanHeaders returns the headers that are not on chain, ordered by height.
+func orphanHeaders(headers map[common.Uint256]*headerInfo, chain []common.Uint256) []*headerInfo {
+	orphans := make([]*headerInfo, 0)
+	for hash, h := range headers {
+		if int(h.height) < len(chain) && chain[h.height] == hash {
+			continue
+		}
+		orphans = append(orphans, h)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].height < orphans[j].height
+	})
+
+	return orphans
+}
+
+// rewriteChainIndex replaces DATA_BlockHash and IX_HeaderHashList with the
+// entries of chain in one batch.
+func rewriteChainIndex(st *db.LevelDBStore, chain []common.Uint256) error {
+	if err := st.NewBatch(); err != nil {
+		return err
+	}
+
+	for _, prefix := range []db.DataEntryPrefix{db.DATA_BlockHash, db.IX_HeaderHashList} {
+		iter := st.NewIterator([]byte{byte(prefix)})
+		for iter.Next() {
+			if err := st.BatchDelete(append([]byte{}, iter.Key()...)); err != nil {
+				iter.Release()
+				return err
+			}
+		}
+		iter.Release()
+	}
+
+	for height, hash := range chain {
+		key := append([]byte{byte(db.DATA_BlockHash)}, heightBytes(uint32(height))...)
+		if err := st.BatchPut(key, hash.ToArray()); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start+headerHashListCount <= len(chain); start += headerHashListCount {
+		key := append([]byte{byte(db.IX_HeaderHashList)}, heightBytes(uint32(start))...)
+
+		value := bytes.NewBuffer(nil)
+		if err := serialization.WriteVarUint(value, uint64(headerHashListCount)); err != nil {
+			return err
+		}
+		for _, hash := range chain[start : start+headerHashListCount] {
+			if _, err := hash.Serialize(value); err != nil {
+				return err
+			}
+		}
+
+		if err := st.BatchPut(key, value.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return st.BatchCommit()
+}
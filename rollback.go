@@ -5,6 +5,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
 
 	"github.com/nknorg/nkn/common"
 	"github.com/nknorg/nkn/common/serialization"
@@ -28,6 +33,29 @@ func NewRollbackCommand() *cli.Command {
 				Usage: "the number of blocks to be rollbacked",
 				Value: 0,
 			},
+			cli.StringFlag{
+				Name:  "backup-dir, b",
+				Usage: "take a snapshot of the db into this directory before rollback",
+			},
+			cli.IntFlag{
+				Name:  "backup-keep",
+				Usage: "the number of snapshots to keep in backup-dir, 0 keeps all",
+				Value: 3,
+			},
+			cli.BoolFlag{
+				Name:  "atomic, a",
+				Usage: "rollback all blocks in a single batch, commit once at the end or not at all",
+			},
+			cli.IntFlag{
+				Name:  "to-height, t",
+				Usage: "rollback until the db reaches this height, overrides num",
+				Value: -1,
+			},
+			cli.IntFlag{
+				Name:  "progress-interval",
+				Usage: "seconds between progress reports",
+				Value: 5,
+			},
 		},
 		Action: rollbackAction,
 		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
@@ -44,28 +72,183 @@ func rollbackAction(c *cli.Context) error {
 
 	path := c.GlobalString("path")
 	num := c.Int("num")
+	backupDir := c.String("backup-dir")
+	backupKeep := c.Int("backup-keep")
+	atomic := c.Bool("atomic")
 
-	st, err := db.NewLevelDBStore(path)
+	st, err := openStore(path)
 	if err != nil {
 		return err
 	}
 
-	for i := 0; i < num; i++ {
-		if currentBlock, err := rollback(st); err != nil {
-			fmt.Println("rollback err:", err)
-			return err
-		} else {
-			hash := currentBlock.Hash()
-			fmt.Printf("rollback block hash:%s, height:%d\n", hash.ToHexString(), currentBlock.Header.Height)
+	if !schema.known {
+		st.Close()
+		return cli.NewExitError(fmt.Sprintf("unknown db version %s, refuse to rollback", schema.version), 1)
+	}
+
+	startHeight, err := getCurrentHeight(st)
+	if err != nil {
+		st.Close()
+		return err
+	}
+	if toHeight := c.Int("to-height"); toHeight >= 0 {
+		num = int(startHeight) - toHeight
+	}
+	if num > int(startHeight) {
+		num = int(startHeight)
+	}
+	if num < 0 {
+		num = 0
+	}
+
+	// pruned blocks lost the transactions needed to undo them
+	if pruned, err := getPrunedHeight(st); err == nil && startHeight-uint32(num)+1 < pruned {
+		st.Close()
+		return cli.NewExitError(fmt.Sprintf("blocks below %d are pruned, refuse to rollback to %d", pruned, startHeight-uint32(num)), 1)
+	} else if err == nil {
+		// a rollback that fails half way can not be resumed, check first that
+		// prune kept every transaction the rolled back blocks spend
+		if err := checkRollbackInputs(st, startHeight-uint32(num)+1, startHeight); err != nil {
+			st.Close()
+			return cli.NewExitError(fmt.Sprintf("the db is pruned and %v, refuse to rollback", err), 1)
+		}
+	}
+
+	if backupDir != "" && num > 0 {
+		backupPath, err := backupStore(st, backupDir)
+		if err != nil {
+			st.Close()
+			fmt.Println("backup err:", err)
+			return cli.NewExitError("backup failed, rollback aborted", 1)
+		}
+		fmt.Println("backup db to", backupPath)
+
+		removed, err := pruneBackups(backupDir, backupKeep)
+		for _, p := range removed {
+			fmt.Println("remove old backup", p)
+		}
+		if err != nil {
+			fmt.Println("prune backup err:", err)
 		}
 	}
 
+	summary := &rollbackSummary{
+		StartHeight:  startHeight,
+		Height:       startHeight,
+		TargetHeight: startHeight - uint32(num),
+		Remaining:    num,
+	}
+	p := newProgress(num, summary.TargetHeight, time.Duration(c.Int("progress-interval"))*time.Second)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	if atomic {
+		err = rollbackAtomic(st, summary, p, interrupt)
+	} else {
+		err = rollbackBlocks(st, summary, p, interrupt)
+	}
+
+	signal.Stop(interrupt)
 	st.Close()
 
+	summary.Elapsed = time.Since(p.start).Seconds()
+	if err != nil {
+		summary.Status = "failed"
+		summary.Error = err.Error()
+	}
+	summary.print()
+
+	return err
+}
+
+// checkRollbackInputs checks that the transactions spent by the blocks from
+// from to to are still in the db.
+func checkRollbackInputs(st store, from uint32, to uint32) error {
+	for height := from; height <= to; height++ {
+		b, err := getBlockByHeight(st, height)
+		if err != nil {
+			return fmt.Errorf("block %d can not be read: %v", height, err)
+		}
+
+		for _, txn := range b.Transactions {
+			for _, input := range txn.Inputs {
+				if _, err := st.Get(append([]byte{byte(db.DATA_Transaction)}, input.ReferTxID.ToArray()...)); err != nil {
+					return fmt.Errorf("block %d spends the missing transaction %s", height, hashString(input.ReferTxID))
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
-func rollback(st *db.LevelDBStore) (*ledger.Block, error) {
+func interrupted(interrupt chan os.Signal) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// rollbackBlocks commits one batch per block. On SIGINT the block in progress
+// is finished and committed before returning.
+func rollbackBlocks(st *db.LevelDBStore, summary *rollbackSummary, p *progress, interrupt chan os.Signal) error {
+	for summary.Remaining > 0 {
+		if interrupted(interrupt) {
+			summary.Status = "interrupted"
+			return nil
+		}
+
+		currentBlock, err := rollback(st)
+		if err != nil {
+			return err
+		}
+
+		summary.Height = currentBlock.Header.Height - 1
+		summary.Rollbacked++
+		summary.Remaining--
+		p.update(summary.Height)
+	}
+	summary.Status = "completed"
+
+	return nil
+}
+
+// rollbackAtomic stages the rollback of all blocks in an overlayStore and
+// writes them to the db in one batch only if every block succeeds. An
+// interrupt discards the staged changes.
+func rollbackAtomic(st *db.LevelDBStore, summary *rollbackSummary, p *progress, interrupt chan os.Signal) error {
+	ov := newOverlayStore(st)
+	var height uint32
+	for staged := 0; staged < summary.Remaining; staged++ {
+		if interrupted(interrupt) {
+			summary.Status = "interrupted"
+			return nil
+		}
+
+		currentBlock, err := rollback(ov)
+		if err != nil {
+			return err
+		}
+
+		height = currentBlock.Header.Height - 1
+		p.update(height)
+	}
+
+	if err := ov.Commit(); err != nil {
+		return err
+	}
+	summary.Height = height
+	summary.Rollbacked = summary.Remaining
+	summary.Remaining = 0
+	summary.Status = "completed"
+
+	return nil
+}
+
+func rollback(st store) (*ledger.Block, error) {
 	if err := st.NewBatch(); err != nil {
 		return nil, err
 	}
@@ -121,7 +304,7 @@ func rollback(st *db.LevelDBStore) (*ledger.Block, error) {
 	return b, st.BatchCommit()
 }
 
-func rollbackHeader(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackHeader(st store, b *ledger.Block) error {
 	blockHash := b.Hash()
 	if err := st.BatchDelete(append([]byte{byte(db.DATA_Header)}, blockHash[:]...)); err != nil {
 		return err
@@ -130,7 +313,7 @@ func rollbackHeader(st *db.LevelDBStore, b *ledger.Block) error {
 	return nil
 }
 
-func rollbackTransaction(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackTransaction(st store, b *ledger.Block) error {
 	for _, txn := range b.Transactions {
 		txHash := txn.Hash()
 		if err := st.BatchDelete(append([]byte{byte(db.DATA_Transaction)}, txHash[:]...)); err != nil {
@@ -141,13 +324,13 @@ func rollbackTransaction(st *db.LevelDBStore, b *ledger.Block) error {
 	return nil
 }
 
-func rollbackBlockHash(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackBlockHash(st store, b *ledger.Block) error {
 	height := make([]byte, 4)
 	binary.LittleEndian.PutUint32(height[:], b.Header.Height)
 	return st.BatchDelete(append([]byte{byte(db.DATA_BlockHash)}, height...))
 }
 
-func rollbackCurrentBlockHash(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackCurrentBlockHash(st store, b *ledger.Block) error {
 	value := new(bytes.Buffer)
 	if _, err := b.Header.PrevBlockHash.Serialize(value); err != nil {
 		return err
@@ -159,7 +342,7 @@ func rollbackCurrentBlockHash(st *db.LevelDBStore, b *ledger.Block) error {
 	return st.BatchPut([]byte{byte(db.SYS_CurrentBlock)}, value.Bytes())
 }
 
-func rollbackHeaderHashlist(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackHeaderHashlist(st store, b *ledger.Block) error {
 	hash := b.Hash()
 	iter := st.NewIterator([]byte{byte(db.IX_HeaderHashList)})
 	var storedHeaderCount uint64
@@ -212,15 +395,24 @@ func rollbackHeaderHashlist(st *db.LevelDBStore, b *ledger.Block) error {
 
 }
 
-func rollbackUnspentIndex(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackUnspentIndex(st store, b *ledger.Block) error {
+	blockTxns := blockTxHashes(b)
 	unspents := make(map[common.Uint256][]uint16)
 	for _, txn := range b.Transactions {
 		txhash := txn.Hash()
-		st.BatchDelete(append([]byte{byte(db.IX_Unspent)}, txhash.ToArray()...))
+		if err := st.BatchDelete(append([]byte{byte(db.IX_Unspent)}, txhash.ToArray()...)); err != nil {
+			return err
+		}
 
 		for _, input := range txn.Inputs {
 			referTxnHash := input.ReferTxID
 			referTxnOutIndex := input.ReferTxOutputIndex
+			// the referred transaction is removed with this block, there is no
+			// unspent index to restore for it.
+			if blockTxns[referTxnHash] {
+				continue
+			}
+
 			if _, ok := unspents[referTxnHash]; !ok {
 				if unspentValue, err := st.Get(append([]byte{byte(db.IX_Unspent)}, referTxnHash.ToArray()...)); err != nil {
 					unspents[referTxnHash] = []uint16{}
@@ -235,13 +427,40 @@ func rollbackUnspentIndex(st *db.LevelDBStore, b *ledger.Block) error {
 	}
 
 	for txhash, value := range unspents {
-		st.BatchPut(append([]byte{byte(db.IX_Unspent)}, txhash.ToArray()...), common.ToByteArray(value))
+		if err := st.BatchPut(append([]byte{byte(db.IX_Unspent)}, txhash.ToArray()...), common.ToByteArray(sortUnspentIndex(value))); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func rollbackUTXO(st *db.LevelDBStore, b *ledger.Block) error {
+// sortUnspentIndex sorts the output indexes and removes duplicates.
+func sortUnspentIndex(index []uint16) []uint16 {
+	sort.Slice(index, func(i, j int) bool { return index[i] < index[j] })
+
+	result := make([]uint16, 0, len(index))
+	for i, v := range index {
+		if i > 0 && v == index[i-1] {
+			continue
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+func blockTxHashes(b *ledger.Block) map[common.Uint256]bool {
+	hashes := make(map[common.Uint256]bool, len(b.Transactions))
+	for _, txn := range b.Transactions {
+		hashes[txn.Hash()] = true
+	}
+
+	return hashes
+}
+
+func rollbackUTXO(st store, b *ledger.Block) error {
+	blockTxns := blockTxHashes(b)
 	unspendUTXOs := make(map[common.Uint160]map[common.Uint256]map[uint32][]*tx.UTXOUnspent)
 	height := b.Header.Height
 
@@ -254,6 +473,10 @@ func rollbackUTXO(st *db.LevelDBStore, b *ledger.Block) error {
 		}
 
 		for _, input := range txn.Inputs {
+			if blockTxns[input.ReferTxID] {
+				continue
+			}
+
 			referTxn, hh, err := getTransaction(st, input.ReferTxID)
 			if err != nil {
 				return err
@@ -291,7 +514,8 @@ func rollbackUTXO(st *db.LevelDBStore, b *ledger.Block) error {
 			for height, unspent := range unspents {
 				heightBuffer := make([]byte, 4)
 				binary.LittleEndian.PutUint32(heightBuffer[:], height)
-				key := append(append(programHash.ToArray(), assetId.ToArray()...), heightBuffer...)
+				key := append(append([]byte{byte(db.IX_Unspent_UTXO)}, programHash.ToArray()...), assetId.ToArray()...)
+				key = append(key, heightBuffer...)
 
 				//TODO if the listnum is 0?
 				listnum := len(unspent)
@@ -319,7 +543,7 @@ func rollbackUTXO(st *db.LevelDBStore, b *ledger.Block) error {
 	return nil
 }
 
-func rollbackAsset(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackAsset(st store, b *ledger.Block) error {
 	for _, txn := range b.Transactions {
 		if txn.TxType == tx.RegisterAsset {
 			txhash := txn.Hash()
@@ -332,7 +556,7 @@ func rollbackAsset(st *db.LevelDBStore, b *ledger.Block) error {
 	return nil
 }
 
-func rollbackIssued(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackIssued(st store, b *ledger.Block) error {
 	quantities := make(map[common.Uint256]common.Fixed64)
 
 	for _, txn := range b.Transactions {
@@ -382,7 +606,7 @@ func rollbackIssued(st *db.LevelDBStore, b *ledger.Block) error {
 	return nil
 }
 
-func rollbackPrepaidAndWithdraw(st *db.LevelDBStore, b *ledger.Block) error {
+func rollbackPrepaidAndWithdraw(st store, b *ledger.Block) error {
 	type prepaid struct {
 		amount common.Fixed64
 		rates  common.Fixed64
@@ -461,10 +685,11 @@ func rollbackPrepaidAndWithdraw(st *db.LevelDBStore, b *ledger.Block) error {
 	return nil
 }
 
-func getUTXOByHeight(st *db.LevelDBStore, programHash common.Uint160, assetid common.Uint256, height uint32) ([]*tx.UTXOUnspent, error) {
+func getUTXOByHeight(st store, programHash common.Uint160, assetid common.Uint256, height uint32) ([]*tx.UTXOUnspent, error) {
 	heightBuffer := make([]byte, 4)
 	binary.LittleEndian.PutUint32(heightBuffer[:], height)
-	key := append(append(programHash.ToArray(), assetid.ToArray()...), heightBuffer...)
+	key := append(append([]byte{byte(db.IX_Unspent_UTXO)}, programHash.ToArray()...), assetid.ToArray()...)
+	key = append(key, heightBuffer...)
 
 	if unspentsData, err := st.Get(key); err != nil {
 		return nil, err
@@ -489,7 +714,7 @@ func getUTXOByHeight(st *db.LevelDBStore, programHash common.Uint160, assetid co
 	}
 }
 
-func getTransaction(st *db.LevelDBStore, hash common.Uint256) (*tx.Transaction, uint32, error) {
+func getTransaction(st store, hash common.Uint256) (*tx.Transaction, uint32, error) {
 	value, err := st.Get(append([]byte{byte(db.DATA_Transaction)}, hash.ToArray()...))
 	if err != nil {
 		return nil, 0, err
@@ -509,7 +734,7 @@ func getTransaction(st *db.LevelDBStore, hash common.Uint256) (*tx.Transaction,
 	return txn, height, nil
 }
 
-func getPrepaid(st *db.LevelDBStore, programhash common.Uint160) (common.Fixed64, common.Fixed64, error) {
+func getPrepaid(st store, programhash common.Uint160) (common.Fixed64, common.Fixed64, error) {
 	value, err := st.Get(append([]byte{byte(db.ST_Prepaid)}, programhash.ToArray()...))
 	if err != nil {
 		return 0, 0, err
@@ -528,7 +753,22 @@ func getPrepaid(st *db.LevelDBStore, programhash common.Uint160) (common.Fixed64
 	return amount, rates, nil
 }
 
-func getCurrentBlock(st *db.LevelDBStore) (*ledger.Block, error) {
+func getCurrentHeight(st store) (uint32, error) {
+	data, err := st.Get([]byte{byte(db.SYS_CurrentBlock)})
+	if err != nil {
+		return 0, err
+	}
+
+	r := bytes.NewReader(data)
+	var currentHash common.Uint256
+	if err := currentHash.Deserialize(r); err != nil {
+		return 0, err
+	}
+
+	return serialization.ReadUint32(r)
+}
+
+func getCurrentBlock(st store) (*ledger.Block, error) {
 	data, err := st.Get([]byte{byte(db.SYS_CurrentBlock)})
 	if err != nil {
 		return nil, err
@@ -544,8 +784,12 @@ func getCurrentBlock(st *db.LevelDBStore) (*ledger.Block, error) {
 		return nil, err
 	}
 
+	if len(header) < schema.headerPrefixSize {
+		return nil, errors.New("invalid header value")
+	}
+
 	b := new(ledger.Block)
-	if err := b.FromTrimmedData(bytes.NewReader(header[8:])); err != nil {
+	if err := b.FromTrimmedData(bytes.NewReader(header[schema.headerPrefixSize:])); err != nil {
 		return nil, err
 	}
 
@@ -558,7 +802,7 @@ func getCurrentBlock(st *db.LevelDBStore) (*ledger.Block, error) {
 	return b, nil
 }
 
-func getProgramHashes(st *db.LevelDBStore, txn *tx.Transaction) ([]common.Uint160, error) {
+func getProgramHashes(st store, txn *tx.Transaction) ([]common.Uint160, error) {
 	if txn == nil {
 		return []common.Uint160{}, errors.New("getProgramHashes transaction is nil.")
 	}
@@ -575,7 +819,7 @@ func getProgramHashes(st *db.LevelDBStore, txn *tx.Transaction) ([]common.Uint16
 	return hashs, nil
 }
 
-func getReference(st *db.LevelDBStore, txn *tx.Transaction) (map[*tx.TxnInput]*tx.TxnOutput, error) {
+func getReference(st store, txn *tx.Transaction) (map[*tx.TxnInput]*tx.TxnOutput, error) {
 	if txn.TxType == tx.RegisterAsset {
 		return nil, nil
 	}
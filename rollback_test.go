@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/nknorg/nkn/common"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/db"
+)
+
+// rollbackPrefixes are the entries a block writes and rollback has to undo.
+var rollbackPrefixes = []db.DataEntryPrefix{
+	db.DATA_Header,
+	db.DATA_BlockHash,
+	db.DATA_Transaction,
+	db.SYS_CurrentBlock,
+	db.IX_Unspent,
+	db.IX_Unspent_UTXO,
+}
+
+// checkRollback adds a block with txns and rolls it back, the store must be
+// the same as before the block.
+func checkRollback(t *testing.T, c *testChain, txns ...*tx.Transaction) {
+	before := c.st.clone()
+	c.addBlock(t, txns...)
+
+	if _, err := rollback(c.st); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range rollbackPrefixes {
+		got, want := c.st.items(prefix), before.items(prefix)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("prefix %x after rollback:\n%s\nwant:\n%s", byte(prefix), dumpItems(got), dumpItems(want))
+		}
+	}
+}
+
+func dumpItems(items map[string]string) string {
+	s := ""
+	for k, v := range items {
+		s += hex.EncodeToString([]byte(k)) + " " + hex.EncodeToString([]byte(v)) + "\n"
+	}
+	return s
+}
+
+func TestRollbackChainInBlock(t *testing.T) {
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(9, 1)))
+
+	// A -> B -> C, all inside the rolled back block
+	a := testTransfer(nil, testOutput(1, 100))
+	b := testTransfer([]*tx.TxnInput{testInput(a, 0)}, testOutput(2, 100))
+	cc := testTransfer([]*tx.TxnInput{testInput(b, 0)}, testOutput(3, 100))
+	checkRollback(t, c, a, b, cc)
+}
+
+func TestRollbackSpendEarlierBlock(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 100), testOutput(2, 50), testOutput(1, 25))
+	c.addBlock(t, a)
+
+	spend := testTransfer([]*tx.TxnInput{testInput(a, 0), testInput(a, 2)}, testOutput(3, 125))
+	checkRollback(t, c, spend)
+}
+
+func TestRollbackUnspentIndexSorted(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 10), testOutput(1, 20), testOutput(1, 30), testOutput(1, 40))
+	c.addBlock(t, a)
+	c.addBlock(t, testTransfer([]*tx.TxnInput{testInput(a, 1)}, testOutput(2, 20)))
+
+	// inputs in reverse order, and two transactions of the block spending
+	// from the same earlier transaction
+	first := testTransfer([]*tx.TxnInput{testInput(a, 3), testInput(a, 0)}, testOutput(2, 50))
+	second := testTransfer([]*tx.TxnInput{testInput(a, 2)}, testOutput(3, 30))
+	c.addBlock(t, first, second)
+
+	if _, err := rollback(c.st); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := c.st.Get(append([]byte{byte(db.IX_Unspent)}, a.Hash().ToArray()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := common.GetUint16Array(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0, 2, 3}; !reflect.DeepEqual(index, want) {
+		t.Fatalf("unspent index %v, want %v", index, want)
+	}
+}
+
+func TestSortUnspentIndex(t *testing.T) {
+	got := sortUnspentIndex([]uint16{5, 1, 3, 1, 5, 0})
+	if want := []uint16{0, 1, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sortUnspentIndex %v, want %v", got, want)
+	}
+}
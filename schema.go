@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/nknorg/nkn/db"
+)
+
+// schemaProfile describes the value layout of one nkn db version.
+type schemaProfile struct {
+	version string
+	known   bool
+	// headerPrefixSize is the length of the system fee stored in front of
+	// the trimmed block in DATA_Header values.
+	headerPrefixSize int
+	prefixes         map[string]db.DataEntryPrefix
+	decoders         map[string]decoder
+}
+
+// schemaProfiles is keyed by the hex CFG_Version value.
+var schemaProfiles map[string]*schemaProfile
+
+// schema is the active profile, it is replaced by loadSchema once a db is
+// opened.
+var schema *schemaProfile
+
+func init() {
+	schemaProfiles = map[string]*schemaProfile{
+		"01": {
+			version:          "01",
+			known:            true,
+			headerPrefixSize: 8,
+			prefixes:         itemPrefixes,
+			decoders:         itemDecoders,
+		},
+	}
+	schema = schemaProfiles["01"]
+}
+
+// openStore opens the db at path and selects the schema profile matching
+// its CFG_Version.
+func openStore(path string) (*db.LevelDBStore, error) {
+	st, err := db.NewLevelDBStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadSchema(st); err != nil {
+		st.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// loadSchema reads CFG_Version and activates its profile. Unknown or missing
+// versions fall back to the default layout marked as unknown, so that read only
+// commands keep working while writes can refuse.
+func loadSchema(st store) error {
+	version := "missing"
+	if value, err := st.Get([]byte{byte(db.CFG_Version)}); err == nil {
+		version = hex.EncodeToString(value)
+		if profile, ok := schemaProfiles[version]; ok {
+			schema = profile
+			return nil
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "warning: unknown db version %s, decoding with the layout of version %s\n", version, schemaProfiles["01"].version)
+	fallback := *schemaProfiles["01"]
+	fallback.version = version
+	fallback.known = false
+	schema = &fallback
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nknorg/nkn/db"
+)
+
+func TestLoadSchema(t *testing.T) {
+	defer func() { schema = schemaProfiles["01"] }()
+
+	st := newMemStore()
+	if err := loadSchema(st); err != nil {
+		t.Fatalf("missing version: %v", err)
+	}
+	if schema.known || schema.headerPrefixSize != schemaProfiles["01"].headerPrefixSize {
+		t.Fatalf("missing version loaded %+v, want the unknown fallback", schema)
+	}
+
+	st.put([]byte{byte(db.CFG_Version)}, []byte{0x7f})
+	if err := loadSchema(st); err != nil || schema.known || schema.version != "7f" {
+		t.Fatalf("unknown version loaded %+v, %v", schema, err)
+	}
+
+	st.put([]byte{byte(db.CFG_Version)}, []byte{0x01})
+	if err := loadSchema(st); err != nil || !schema.known {
+		t.Fatalf("version 01 loaded %+v, %v", schema, err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &apiError{status: http.StatusBadRequest, err: err}
+}
+
+func notFound(err error) error {
+	return &apiError{status: http.StatusNotFound, err: err}
+}
+
+// apiServer serves read-only JSON views of the db, values are decoded with
+// the same decoders as export.
+type apiServer struct {
+	st *db.LevelDBStore
+}
+
+func NewServeCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "serve",
+		Usage:       "serve db items over http",
+		Description: "serve read-only json endpoints: /height, /block/{height|hash}, /header/{hash}, /tx/{hash}, /utxo/{programhash}, /prepaid/{programhash}, /asset/{id}, /stats",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "listen, l",
+				Usage: "the address to listen on",
+				Value: "127.0.0.1:30004",
+			},
+		},
+		Action: serveAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func serveAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	s := &apiServer{st: st}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/height", s.handler("/height", s.height))
+	mux.HandleFunc("/block/", s.handler("/block/", s.block))
+	mux.HandleFunc("/header/", s.handler("/header/", s.header))
+	mux.HandleFunc("/tx/", s.handler("/tx/", s.tx))
+	mux.HandleFunc("/utxo/", s.handler("/utxo/", s.utxo))
+	mux.HandleFunc("/prepaid/", s.handler("/prepaid/", s.prepaid))
+	mux.HandleFunc("/asset/", s.handler("/asset/", s.asset))
+	mux.HandleFunc("/stats", s.handler("/stats", s.stats))
+
+	listen := c.String("listen")
+	fmt.Println("serving db", c.GlobalString("path"), "on", listen)
+	return http.ListenAndServe(listen, mux)
+}
+
+func (s *apiServer) handler(path string, fn func(arg string) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			writeAPIError(w, &apiError{status: http.StatusMethodNotAllowed, err: errors.New("method not allowed")})
+			return
+		}
+
+		v, err := fn(strings.TrimPrefix(r.URL.Path, path))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*apiError); ok {
+		status = e.status
+	}
+
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// get reads one item by its hex key suffix and decodes it.
+func (s *apiServer) get(item string, keystr string) (interface{}, error) {
+	key, err := itemKey(item, keystr)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	value, err := s.st.Get(key)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	v, err := decodeItem(s.st, item, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return current{Key: hex.EncodeToString(key), Value: v}, nil
+}
+
+func (s *apiServer) height(arg string) (interface{}, error) {
+	return s.get("currentblockhash", "")
+}
+
+func (s *apiServer) block(arg string) (interface{}, error) {
+	if len(arg) != 64 {
+		if _, err := strconv.ParseUint(arg, 10, 32); err != nil {
+			return nil, badRequest(errors.New("invalid height or hash: " + arg))
+		}
+	}
+
+	hash, err := resolveBlockHash(s.st, arg)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return s.get("block", hashString(hash))
+}
+
+func (s *apiServer) header(arg string) (interface{}, error) {
+	return s.get("header", arg)
+}
+
+func (s *apiServer) tx(arg string) (interface{}, error) {
+	return s.get("transaction", arg)
+}
+
+func (s *apiServer) prepaid(arg string) (interface{}, error) {
+	return s.get("prepaid", arg)
+}
+
+func (s *apiServer) asset(arg string) (interface{}, error) {
+	return s.get("asset", arg)
+}
+
+// utxo lists every IX_Unspent_UTXO entry of a program hash, one per asset
+// and height.
+func (s *apiServer) utxo(arg string) (interface{}, error) {
+	if len(arg) != 40 {
+		return nil, badRequest(errors.New("invalid programhash: " + arg))
+	}
+
+	prefix, err := itemKey("utxo", arg)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	items := make([]current, 0)
+	iter := s.st.NewIterator(prefix)
+	defer iter.Release()
+	for iter.Next() {
+		v, err := decodeUTXO(s.st, iter.Key(), iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, current{Key: hex.EncodeToString(iter.Key()), Value: v})
+	}
+
+	return items, nil
+}
+
+func (s *apiServer) stats(arg string) (interface{}, error) {
+	return getStats(s.st)
+}
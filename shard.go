@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nknorg/nkn/db"
+)
+
+// shardPrefixes splits the key range under key into n contiguous shards.
+// Each shard is a list of prefixes made of key and one more byte, so the
+// shards in order cover the range in key order.
+func shardPrefixes(key []byte, n int) [][][]byte {
+	if n > 256 {
+		n = 256
+	}
+
+	shards := make([][][]byte, n)
+	for b := 0; b < 256; b++ {
+		i := b * n / 256
+		shards[i] = append(shards[i], append(append([]byte{}, key...), byte(b)))
+	}
+
+	return shards
+}
+
+// exportParallel exports the items under key with one worker per shard.
+// The shard outputs are merged into filename in key order unless perShard
+// is set, in which case every shard is written as its own export.
+func exportParallel(filename string, st *db.LevelDBStore, item string, key []byte, israw bool, workers int, perShard bool, opts outputOptions) error {
+	shards := shardPrefixes(key, workers)
+	names := make([]string, len(shards))
+	errs := make([]error, len(shards))
+
+	var wg sync.WaitGroup
+	for i := range shards {
+		names[i] = fmt.Sprintf("%s.shard%03d", filename, i)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var w recordWriter
+			if perShard {
+				w = newChunkWriter(names[i], item, opts)
+			} else {
+				f, err := createFile(names[i])
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				w = &shardFile{f: f, w: bufio.NewWriter(f)}
+			}
+			errs[i] = exportShard(w, st, item, key, shards[i], israw, i == 0)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+
+	if perShard {
+		return nil
+	}
+
+	return mergeShards(newChunkWriter(filename, item, opts), names)
+}
+
+// exportShard writes the items of one shard. The first shard also writes
+// the item stored at key itself, which sorts before every longer key.
+func exportShard(w recordWriter, st *db.LevelDBStore, item string, key []byte, prefixes [][]byte, israw bool, first bool) error {
+	if first {
+		if value, err := st.Get(key); err == nil {
+			if err := w.writeRecord(key, exportLine(st, item, key, value, israw)); err != nil {
+				w.Close()
+				return err
+			}
+		}
+	}
+
+	for _, prefix := range prefixes {
+		iter := st.NewIterator(prefix)
+		for iter.Next() {
+			if err := w.writeRecord(iter.Key(), exportLine(st, item, iter.Key(), iter.Value(), israw)); err != nil {
+				iter.Release()
+				w.Close()
+				return err
+			}
+		}
+		iter.Release()
+	}
+
+	return w.Close()
+}
+
+// shardFile is the temporary output of a shard that is merged later, every
+// line is prefixed with its hex key and a tab.
+type shardFile struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func (s *shardFile) writeRecord(key []byte, line string) error {
+	_, err := s.w.WriteString(hex.EncodeToString(key) + "\t" + line)
+	return err
+}
+
+func (s *shardFile) Close() error {
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// mergeShards copies the shard files in order into w and removes them.
+func mergeShards(w recordWriter, names []string) error {
+	for _, name := range names {
+		path := filepath.Join("./exports", name)
+		f, err := os.Open(path)
+		if err != nil {
+			w.Close()
+			return err
+		}
+
+		err = copyShard(w, bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("merge %s: %v", name, err)
+		}
+		if err := os.Remove(path); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+func copyShard(w recordWriter, r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			return fmt.Errorf("invalid shard line")
+		}
+		key, err := hex.DecodeString(line[:i])
+		if err != nil {
+			return err
+		}
+		if err := w.writeRecord(key, line[i+1:]); err != nil {
+			return err
+		}
+	}
+}
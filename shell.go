@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/common/serialization"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/db"
+	"github.com/peterh/liner"
+	"github.com/urfave/cli"
+)
+
+const (
+	shellPrompt      = "dbtool> "
+	shellHistoryFile = ".dbtool_history"
+	shellScanLimit   = 20
+)
+
+type shellCommand struct {
+	usage  string
+	action func(st *db.LevelDBStore, w io.Writer, args []string) error
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"get":     {"get <item> <key>            get and decode one item", shellGet},
+		"scan":    {"scan <item> [start] [limit] list items by prefix", shellScan},
+		"block":   {"block <height|hash>         show a block", shellBlock},
+		"tx":      {"tx <hash>                   show a transaction", shellTx},
+		"balance": {"balance <addr>              sum the unspent outputs of an address", shellBalance},
+		"height":  {"height                      show the current block height", shellHeight},
+		"help":    {"help                        show this help", shellHelp},
+	}
+}
+
+func NewShellCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "shell",
+		Usage:       "interactive shell for exploring the db",
+		Description: "open the db once and explore it with get, scan, block, tx, balance and height",
+		Action:      shellAction,
+	}
+}
+
+func shellAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(shellComplete)
+
+	history := shellHistoryPath()
+	if f, err := os.Open(history); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+
+	for {
+		input, err := line.Prompt(shellPrompt)
+		if err == liner.ErrPromptAborted || err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		args := strings.Fields(input)
+		if len(args) == 0 {
+			continue
+		}
+		line.AppendHistory(input)
+
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+
+		cmd, ok := shellCommands[args[0]]
+		if !ok {
+			fmt.Println("unknown command:", args[0])
+			continue
+		}
+		if err := cmd.action(st, os.Stdout, args[1:]); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+
+	if f, err := os.Create(history); err == nil {
+		line.WriteHistory(f)
+		f.Close()
+	}
+
+	return nil
+}
+
+func shellHistoryPath() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return shellHistoryFile
+	}
+	return filepath.Join(home, shellHistoryFile)
+}
+
+// shellComplete completes command names and, for get and scan, item names.
+func shellComplete(input string) []string {
+	fields := strings.Fields(input)
+	candidates := make([]string, 0)
+
+	if len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(input, " ")) {
+		for name := range shellCommands {
+			if strings.HasPrefix(name, input) {
+				candidates = append(candidates, name+" ")
+			}
+		}
+		return candidates
+	}
+
+	if fields[0] != "get" && fields[0] != "scan" {
+		return candidates
+	}
+
+	partial := ""
+	if len(fields) == 2 && !strings.HasSuffix(input, " ") {
+		partial = fields[1]
+	} else if len(fields) > 1 {
+		return candidates
+	}
+
+	for _, name := range itemNames() {
+		if strings.HasPrefix(name, partial) {
+			candidates = append(candidates, fields[0]+" "+name+" ")
+		}
+	}
+
+	return candidates
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
+
+func shellHelp(st *db.LevelDBStore, w io.Writer, args []string) error {
+	for _, name := range []string{"get", "scan", "block", "tx", "balance", "height", "help"} {
+		fmt.Fprintln(w, "  "+shellCommands[name].usage)
+	}
+	fmt.Fprintln(w, "  exit")
+	fmt.Fprintln(w, "items:", strings.Join(itemNames(), ", "))
+	return nil
+}
+
+func shellGet(st *db.LevelDBStore, w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: " + shellCommands["get"].usage)
+	}
+
+	keystr := ""
+	if len(args) > 1 {
+		keystr = args[1]
+	}
+	key, err := itemKey(args[0], keystr)
+	if err != nil {
+		return err
+	}
+
+	value, err := st.Get(key)
+	if err != nil {
+		return err
+	}
+
+	v, err := decodeItem(st, args[0], key, value)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(w, current{Key: hex.EncodeToString(key), Value: v})
+}
+
+func shellScan(st *db.LevelDBStore, w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: " + shellCommands["scan"].usage)
+	}
+
+	prefix, err := itemKey(args[0], "")
+	if err != nil {
+		return err
+	}
+
+	start := prefix
+	if len(args) > 1 {
+		if start, err = itemKey(args[0], args[1]); err != nil {
+			return err
+		}
+	}
+
+	limit := shellScanLimit
+	if len(args) > 2 {
+		if limit, err = strconv.Atoi(args[2]); err != nil {
+			return err
+		}
+	}
+
+	iter := st.NewIterator(prefix)
+	defer iter.Release()
+
+	count := 0
+	for ok := iter.Seek(start); ok && count < limit; ok = iter.Next() {
+		v, err := decodeItem(st, args[0], iter.Key(), iter.Value())
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", hex.EncodeToString(iter.Key()), err)
+		} else {
+			data, _ := json.Marshal(v)
+			fmt.Fprintf(w, "%s: %s\n", hex.EncodeToString(iter.Key()), string(data))
+		}
+		count++
+	}
+
+	return nil
+}
+
+func shellBlock(st *db.LevelDBStore, w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: " + shellCommands["block"].usage)
+	}
+
+	b, err := getBlockByHeightOrHash(st, args[0])
+	if err != nil {
+		return err
+	}
+
+	return printBlock(w, st, b)
+}
+
+func shellTx(st *db.LevelDBStore, w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: " + shellCommands["tx"].usage)
+	}
+
+	hash, err := parseHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	txn, height, err := getTransaction(st, hash)
+	if err != nil {
+		return err
+	}
+
+	txMarshal, err := txn.MarshalJson()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "height: %d\n", height)
+	var out bytes.Buffer
+	if err := json.Indent(&out, txMarshal, "", "  "); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, out.String())
+
+	return nil
+}
+
+// getBalance sums the unspent outputs of a program hash per asset.
+func getBalance(st store, programHash common.Uint160) (map[common.Uint256]common.Fixed64, error) {
+	balances := make(map[common.Uint256]common.Fixed64)
+	iter := st.NewIterator(append([]byte{byte(db.IX_Unspent_UTXO)}, programHash.ToArray()...))
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < 1+20+32 {
+			continue
+		}
+		assetID, err := common.Uint256ParseFromBytes(key[21:53])
+		if err != nil {
+			return nil, err
+		}
+
+		r := bytes.NewReader(iter.Value())
+		listNum, err := serialization.ReadVarUint(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < int(listNum); i++ {
+			uu := new(tx.UTXOUnspent)
+			if err := uu.Deserialize(r); err != nil {
+				return nil, err
+			}
+			balances[assetID] += uu.Value
+		}
+	}
+
+	return balances, nil
+}
+
+func shellBalance(st *db.LevelDBStore, w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: " + shellCommands["balance"].usage)
+	}
+
+	programHash, err := common.ToScriptHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	balances, err := getBalance(st, programHash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "programhash: %s\n", hex.EncodeToString(programHash.ToArray()))
+	if len(balances) == 0 {
+		fmt.Fprintln(w, "no unspent outputs")
+	}
+	for assetID, value := range balances {
+		fmt.Fprintf(w, "  asset %s: %s\n", hashString(assetID), value.String())
+	}
+
+	return nil
+}
+
+func shellHeight(st *db.LevelDBStore, w io.Writer, args []string) error {
+	height, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, height)
+	return nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqlBatchRows = 10000
+
+// sqlTables creates the tables of export-sql. Hashes are hex strings and
+// amounts are integers in Fixed64 units.
+var sqlTables = []string{
+	`CREATE TABLE blocks (
+		height INTEGER PRIMARY KEY,
+		hash TEXT NOT NULL,
+		system_fee INTEGER NOT NULL,
+		tx_count INTEGER NOT NULL
+	)`,
+	`CREATE TABLE headers (
+		hash TEXT PRIMARY KEY,
+		height INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		prev_hash TEXT NOT NULL,
+		transactions_root TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		consensus_data INTEGER NOT NULL,
+		next_bookkeeper TEXT NOT NULL
+	)`,
+	`CREATE TABLE transactions (
+		hash TEXT PRIMARY KEY,
+		height INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		input_count INTEGER NOT NULL,
+		output_count INTEGER NOT NULL,
+		size INTEGER NOT NULL
+	)`,
+	`CREATE TABLE inputs (
+		tx_hash TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		refer_tx_hash TEXT NOT NULL,
+		refer_index INTEGER NOT NULL,
+		PRIMARY KEY (tx_hash, idx)
+	)`,
+	`CREATE TABLE outputs (
+		tx_hash TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		asset_id TEXT NOT NULL,
+		value INTEGER NOT NULL,
+		program_hash TEXT NOT NULL,
+		address TEXT NOT NULL,
+		PRIMARY KEY (tx_hash, idx)
+	)`,
+	`CREATE TABLE utxos (
+		program_hash TEXT NOT NULL,
+		address TEXT NOT NULL,
+		asset_id TEXT NOT NULL,
+		height INTEGER NOT NULL,
+		tx_hash TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		value INTEGER NOT NULL
+	)`,
+	`CREATE TABLE assets (
+		asset_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL,
+		precision INTEGER NOT NULL,
+		asset_type INTEGER NOT NULL,
+		record_type INTEGER NOT NULL
+	)`,
+	`CREATE TABLE issued (
+		asset_id TEXT PRIMARY KEY,
+		amount INTEGER NOT NULL
+	)`,
+	`CREATE TABLE prepaid (
+		program_hash TEXT PRIMARY KEY,
+		address TEXT NOT NULL,
+		amount INTEGER NOT NULL,
+		rates INTEGER NOT NULL
+	)`,
+}
+
+// sqlIndexes are created after the tables are filled.
+var sqlIndexes = []string{
+	"CREATE UNIQUE INDEX blocks_hash ON blocks (hash)",
+	"CREATE INDEX headers_height ON headers (height)",
+	"CREATE INDEX transactions_height ON transactions (height)",
+	"CREATE INDEX transactions_type ON transactions (type)",
+	"CREATE INDEX inputs_refer ON inputs (refer_tx_hash, refer_index)",
+	"CREATE INDEX outputs_program_hash ON outputs (program_hash)",
+	"CREATE INDEX outputs_address ON outputs (address)",
+	"CREATE INDEX outputs_asset_id ON outputs (asset_id)",
+	"CREATE INDEX utxos_program_hash ON utxos (program_hash, asset_id)",
+	"CREATE INDEX utxos_address ON utxos (address)",
+	"CREATE INDEX utxos_tx ON utxos (tx_hash, idx)",
+	"CREATE INDEX prepaid_address ON prepaid (address)",
+}
+
+// sqlLoader inserts rows with prepared statements and commits every
+// sqlBatchRows rows.
+type sqlLoader struct {
+	db    *sql.DB
+	tx    *sql.Tx
+	stmts map[string]*sql.Stmt
+	rows  int
+	total map[string]int
+}
+
+func NewExportSQLCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "export-sql",
+		Usage:       "export the db into a SQLite database",
+		Description: "create blocks, headers, transactions, inputs, outputs, utxos, assets, issued and prepaid tables with indexes and fill them from the db",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "out, o",
+				Usage: "the SQLite file to create",
+			},
+			cli.BoolFlag{
+				Name:  "force, f",
+				Usage: "replace the out file if it exists",
+			},
+		},
+		Action: exportSQLAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func exportSQLAction(c *cli.Context) error {
+	out := c.String("out")
+	if out == "" {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+
+	if exist, err := PathExists(out); err != nil {
+		return err
+	} else if exist {
+		if !c.Bool("force") {
+			return cli.NewExitError(out+" already exists, use --force to replace it", 1)
+		}
+		if err := os.Remove(out); err != nil {
+			return err
+		}
+	}
+
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	sqldb, err := sql.Open("sqlite3", out)
+	if err != nil {
+		return err
+	}
+	defer sqldb.Close()
+
+	l := &sqlLoader{db: sqldb, total: make(map[string]int)}
+	if err := l.exportAll(st); err != nil {
+		// do not leave a half filled database behind
+		l.rollback()
+		sqldb.Close()
+		os.Remove(out)
+		return err
+	}
+
+	for _, table := range []string{"blocks", "headers", "transactions", "inputs", "outputs", "utxos", "assets", "issued", "prepaid"} {
+		fmt.Printf("%s: %d rows\n", table, l.total[table])
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) exportAll(st store) error {
+	for _, table := range sqlTables {
+		if _, err := l.db.Exec(table); err != nil {
+			return err
+		}
+	}
+
+	steps := []func(store) error{
+		l.exportBlocks,
+		l.exportHeaders,
+		l.exportTransactions,
+		l.exportUTXOs,
+		l.exportAssets,
+		l.exportIssued,
+		l.exportPrepaid,
+	}
+	for _, step := range steps {
+		if err := step(st); err != nil {
+			return err
+		}
+	}
+	if err := l.commit(); err != nil {
+		return err
+	}
+
+	for _, index := range sqlIndexes {
+		if _, err := l.db.Exec(index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) insert(table string, args ...interface{}) error {
+	if l.tx == nil {
+		tx, err := l.db.Begin()
+		if err != nil {
+			return err
+		}
+		l.tx = tx
+		l.stmts = make(map[string]*sql.Stmt)
+	}
+
+	stmt, ok := l.stmts[table]
+	if !ok {
+		query := fmt.Sprintf("INSERT INTO %s VALUES (%s)", table, strings.TrimSuffix(strings.Repeat("?,", len(args)), ","))
+		var err error
+		if stmt, err = l.tx.Prepare(query); err != nil {
+			return err
+		}
+		l.stmts[table] = stmt
+	}
+
+	if _, err := stmt.Exec(args...); err != nil {
+		return fmt.Errorf("insert into %s: %v", table, err)
+	}
+	l.total[table]++
+
+	l.rows++
+	if l.rows >= sqlBatchRows {
+		return l.commit()
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) commit() error {
+	if l.tx == nil {
+		return nil
+	}
+
+	for _, stmt := range l.stmts {
+		stmt.Close()
+	}
+	err := l.tx.Commit()
+	l.tx = nil
+	l.rows = 0
+
+	return err
+}
+
+func (l *sqlLoader) rollback() {
+	if l.tx != nil {
+		l.tx.Rollback()
+		l.tx = nil
+	}
+}
+
+func addressString(programHash common.Uint160) string {
+	addr, err := programHash.ToAddress()
+	if err != nil {
+		return ""
+	}
+	return addr
+}
+
+// exportBlocks fills blocks from DATA_BlockHash, so it holds the main chain.
+func (l *sqlLoader) exportBlocks(st store) error {
+	iter := st.NewIterator([]byte{byte(db.DATA_BlockHash)})
+	defer iter.Release()
+	for iter.Next() {
+		height, err := parseBlockhashKey(iter.Key())
+		if err != nil {
+			return err
+		}
+
+		hash, err := common.Uint256ParseFromBytes(iter.Value())
+		if err != nil {
+			return err
+		}
+		data, err := st.Get(append([]byte{byte(db.DATA_Header)}, hash.ToArray()...))
+		if err != nil {
+			return fmt.Errorf("header of height %d: %v", height, err)
+		}
+		fee, err := parseHeaderFee(data)
+		if err != nil {
+			return fmt.Errorf("header of height %d: %v", height, err)
+		}
+		b, err := parseTrimmedBlock(data)
+		if err != nil {
+			return fmt.Errorf("header of height %d: %v", height, err)
+		}
+
+		if err := l.insert("blocks", height, hashString(hash), int64(fee), len(b.Transactions)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportHeaders fills headers from every DATA_Header entry, including
+// headers off the main chain.
+func (l *sqlLoader) exportHeaders(st store) error {
+	iter := st.NewIterator([]byte{byte(db.DATA_Header)})
+	defer iter.Release()
+	for iter.Next() {
+		h, err := parseHeader(iter.Value())
+		if err != nil {
+			return fmt.Errorf("header %x: %v", iter.Key()[1:], err)
+		}
+
+		err = l.insert("headers", hex.EncodeToString(iter.Key()[1:]), h.Height, h.Version,
+			hashString(h.PrevBlockHash), hashString(h.TransactionsRoot), h.Timestamp,
+			int64(h.ConsensusData), hex.EncodeToString(h.NextBookKeeper.ToArray()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) exportTransactions(st store) error {
+	iter := st.NewIterator([]byte{byte(db.DATA_Transaction)})
+	defer iter.Release()
+	for iter.Next() {
+		hash := hex.EncodeToString(iter.Key()[1:])
+		height, txn, err := parseTransaction(iter.Value())
+		if err != nil {
+			return fmt.Errorf("transaction %s: %v", hash, err)
+		}
+
+		err = l.insert("transactions", hash, height, txTypeName(txn.TxType), len(txn.Inputs), len(txn.Outputs), len(iter.Value())-4)
+		if err != nil {
+			return err
+		}
+
+		for i, input := range txn.Inputs {
+			if err := l.insert("inputs", hash, i, hashString(input.ReferTxID), input.ReferTxOutputIndex); err != nil {
+				return err
+			}
+		}
+		for i, output := range txn.Outputs {
+			err := l.insert("outputs", hash, i, hashString(output.AssetID), int64(output.Value),
+				hex.EncodeToString(output.ProgramHash.ToArray()), addressString(output.ProgramHash))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) exportUTXOs(st store) error {
+	iter := st.NewIterator([]byte{byte(db.IX_Unspent_UTXO)})
+	defer iter.Release()
+	for iter.Next() {
+		programHash, assetID, height, err := parseUTXOKey(iter.Key())
+		if err != nil {
+			return err
+		}
+		unspents, err := parseUTXOList(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		for _, u := range unspents {
+			err := l.insert("utxos", hex.EncodeToString(programHash.ToArray()), addressString(programHash),
+				hashString(assetID), height, hashString(u.Txid), u.Index, int64(u.Value))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) exportAssets(st store) error {
+	iter := st.NewIterator([]byte{byte(db.ST_Info)})
+	defer iter.Release()
+	for iter.Next() {
+		a, err := parseAsset(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		err = l.insert("assets", hex.EncodeToString(iter.Key()[1:]), a.Name, a.Description,
+			a.Precision, byte(a.AssetType), byte(a.RecordType))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) exportIssued(st store) error {
+	iter := st.NewIterator([]byte{byte(db.ST_QuantityIssued)})
+	defer iter.Release()
+	for iter.Next() {
+		amount, err := parseIssued(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		if err := l.insert("issued", hex.EncodeToString(iter.Key()[1:]), int64(amount)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *sqlLoader) exportPrepaid(st store) error {
+	iter := st.NewIterator([]byte{byte(db.ST_Prepaid)})
+	defer iter.Release()
+	for iter.Next() {
+		programHash, err := common.Uint160ParseFromBytes(iter.Key()[1:])
+		if err != nil {
+			return err
+		}
+
+		amount, rates, err := parsePrepaid(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		err = l.insert("prepaid", hex.EncodeToString(programHash.ToArray()), addressString(programHash),
+			int64(amount), int64(rates))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+type prefixStats struct {
+	Prefix     string `json:"prefix"`
+	Name       string `json:"name"`
+	Keys       uint64 `json:"keys"`
+	KeyBytes   uint64 `json:"keyBytes"`
+	ValueBytes uint64 `json:"valueBytes"`
+}
+
+type dbStats struct {
+	Height   uint32         `json:"height"`
+	Keys     uint64         `json:"keys"`
+	Bytes    uint64         `json:"bytes"`
+	Prefixes []*prefixStats `json:"prefixes"`
+}
+
+// prefixName returns the item name of a db prefix byte. block shares its
+// prefix with header and is never returned.
+func prefixName(prefix byte) string {
+	if prefix == dbtoolPrefix {
+		return "dbtool"
+	}
+	for name, p := range schema.prefixes {
+		if byte(p) == prefix && name != "block" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// getStats scans the whole db and counts keys and bytes per prefix.
+func getStats(st store) (*dbStats, error) {
+	stats := &dbStats{Prefixes: make([]*prefixStats, 0)}
+	byPrefix := make(map[byte]*prefixStats)
+
+	iter := st.NewIterator(nil)
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) == 0 {
+			continue
+		}
+
+		ps, ok := byPrefix[key[0]]
+		if !ok {
+			ps = &prefixStats{Prefix: fmt.Sprintf("%02x", key[0]), Name: prefixName(key[0])}
+			byPrefix[key[0]] = ps
+			stats.Prefixes = append(stats.Prefixes, ps)
+		}
+		ps.Keys++
+		ps.KeyBytes += uint64(len(key))
+		ps.ValueBytes += uint64(len(iter.Value()))
+
+		stats.Keys++
+		stats.Bytes += uint64(len(key) + len(iter.Value()))
+	}
+	iter.Release()
+
+	sort.Slice(stats.Prefixes, func(i, j int) bool {
+		return stats.Prefixes[i].Prefix < stats.Prefixes[j].Prefix
+	})
+
+	height, err := getCurrentHeight(st)
+	if err != nil {
+		return nil, err
+	}
+	stats.Height = height
+
+	return stats, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/nknorg/nkn/common/serialization"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+// snapshotUTXO is one line of a utxo snapshot.
+type snapshotUTXO struct {
+	Txid        string `json:"txid"`
+	Index       uint32 `json:"index"`
+	Value       int64  `json:"value"`
+	AssetID     string `json:"assetid"`
+	ProgramHash string `json:"programhash"`
+	Height      uint32 `json:"height"`
+}
+
+type snapshotSummary struct {
+	Height     uint32 `json:"height"`
+	BlockHash  string `json:"blockhash"`
+	Count      int    `json:"count"`
+	Commitment string `json:"commitment"`
+}
+
+func NewUTXOSnapshotCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "utxo-snapshot",
+		Usage:       "write the utxo set at a height with a commitment hash",
+		Description: "write every unspent output in key order followed by txid and index, and a rolling SHA-256 over them that matches on every node with the same utxo set. heights below the current one are reached by staging a rollback in memory",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "height",
+				Usage: "the height of the snapshot, the current height by default",
+				Value: -1,
+			},
+		},
+		Action: utxoSnapshotAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func utxoSnapshotAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	current, err := getCurrentHeight(st)
+	if err != nil {
+		return err
+	}
+
+	height := current
+	if h := c.Int("height"); h >= 0 {
+		height = uint32(h)
+	}
+	if height > current {
+		return cli.NewExitError(fmt.Sprintf("height %d is above the current height %d", height, current), 1)
+	}
+	if height < current && !schema.known {
+		return cli.NewExitError("unknown db version "+schema.version+", can not roll back to an earlier height", 1)
+	}
+	if pruned, err := getPrunedHeight(st); err == nil && height < pruned {
+		return cli.NewExitError(fmt.Sprintf("blocks below %d are pruned, can not roll back to %d", pruned, height), 1)
+	}
+
+	// the rollback is staged in memory and never committed
+	ov := newOverlayStore(st)
+	for h := current; h > height; h-- {
+		if _, err := rollback(ov); err != nil {
+			return fmt.Errorf("stage rollback of block %d: %v", h, err)
+		}
+	}
+
+	hash, err := getBlockHash(ov, height)
+	if err != nil {
+		return err
+	}
+
+	f, err := createFile(fmt.Sprintf("utxo_snapshot_%d.txt", height))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	summary := &snapshotSummary{Height: height, BlockHash: hashString(hash)}
+	commitment, err := writeUTXOSnapshot(ov, w, summary)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	summary.Commitment = hex.EncodeToString(commitment)
+
+	sf, err := createFile(fmt.Sprintf("utxo_snapshot_%d.json", height))
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	if err := printJSON(sf, summary); err != nil {
+		return err
+	}
+
+	fmt.Printf("height:%d, blockhash:%s, utxos:%d, commitment:%s\n", summary.Height, summary.BlockHash, summary.Count, summary.Commitment)
+
+	return nil
+}
+
+// writeUTXOSnapshot writes the utxo set as JSON lines and returns the
+// commitment, a SHA-256 chained over the binary form of every utxo in the
+// same order.
+func writeUTXOSnapshot(st store, w *bufio.Writer, summary *snapshotSummary) ([]byte, error) {
+	commitment := make([]byte, sha256.Size)
+
+	iter := st.NewIterator([]byte{byte(db.IX_Unspent_UTXO)})
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 57 {
+			return nil, fmt.Errorf("invalid utxo key %x", key)
+		}
+		programHash, assetID := key[1:21], key[21:53]
+		height := binary.LittleEndian.Uint32(key[53:])
+
+		r := bytes.NewReader(iter.Value())
+		count, err := serialization.ReadVarUint(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		unspents := make([]*tx.UTXOUnspent, 0, count)
+		for i := uint64(0); i < count; i++ {
+			u := new(tx.UTXOUnspent)
+			if err := u.Deserialize(r); err != nil {
+				return nil, err
+			}
+			unspents = append(unspents, u)
+		}
+
+		// the order inside a value depends on the order blocks were applied
+		sort.Slice(unspents, func(i, j int) bool {
+			if c := bytes.Compare(unspents[i].Txid.ToArray(), unspents[j].Txid.ToArray()); c != 0 {
+				return c < 0
+			}
+			return unspents[i].Index < unspents[j].Index
+		})
+
+		for _, u := range unspents {
+			record := bytes.NewBuffer(nil)
+			record.Write(commitment)
+			record.Write(u.Txid.ToArray())
+			binary.Write(record, binary.LittleEndian, u.Index)
+			binary.Write(record, binary.LittleEndian, int64(u.Value))
+			record.Write(assetID)
+			record.Write(programHash)
+			binary.Write(record, binary.LittleEndian, height)
+			sum := sha256.Sum256(record.Bytes())
+			commitment = sum[:]
+
+			line, _ := json.Marshal(snapshotUTXO{
+				Txid:        hashString(u.Txid),
+				Index:       u.Index,
+				Value:       int64(u.Value),
+				AssetID:     hex.EncodeToString(assetID),
+				ProgramHash: hex.EncodeToString(programHash),
+				Height:      height,
+			})
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return nil, err
+			}
+			summary.Count++
+		}
+	}
+
+	return commitment, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	tx "github.com/nknorg/nkn/core/transaction"
+)
+
+func snapshotOf(t *testing.T, st store) (string, []byte) {
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	commitment, err := writeUTXOSnapshot(st, w, &snapshotSummary{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), commitment
+}
+
+func TestSnapshotStagedRollback(t *testing.T) {
+	c := newTestChain()
+	a := testTransfer(nil, testOutput(1, 100), testOutput(2, 50))
+	c.addBlock(t, a)
+	b := testTransfer(nil, testOutput(3, 70))
+	c.addBlock(t, b)
+
+	wantLines, wantCommitment := snapshotOf(t, c.st)
+	if wantLines == "" {
+		t.Fatal("empty snapshot before the last block")
+	}
+
+	// spend an output of an earlier block, and an output of the same block
+	spend := testTransfer([]*tx.TxnInput{testInput(a, 0)}, testOutput(4, 60), testOutput(1, 40))
+	chained := testTransfer([]*tx.TxnInput{testInput(spend, 1), testInput(b, 0)}, testOutput(2, 110))
+	c.addBlock(t, spend, chained)
+
+	ov := newOverlayStore(c.st)
+	if _, err := rollback(ov); err != nil {
+		t.Fatal(err)
+	}
+
+	gotLines, gotCommitment := snapshotOf(t, ov)
+	if gotLines != wantLines {
+		t.Fatalf("staged snapshot\n%s\nwant\n%s", gotLines, wantLines)
+	}
+	if !bytes.Equal(gotCommitment, wantCommitment) {
+		t.Fatalf("commitment %x, want %x", gotCommitment, wantCommitment)
+	}
+}
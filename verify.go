@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nknorg/nkn/common"
+	"github.com/nknorg/nkn/core/ledger"
+	tx "github.com/nknorg/nkn/core/transaction"
+	"github.com/nknorg/nkn/crypto"
+	"github.com/nknorg/nkn/db"
+	"github.com/urfave/cli"
+)
+
+type verifier struct {
+	st     store
+	issues int
+}
+
+func NewVerifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "verify",
+		Usage:       "verify the chain and its indexes",
+		Description: "check that the main chain is linked, DATA_BlockHash matches it and every block transaction is stored. --deep also recomputes header, transaction and merkle root hashes",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "deep",
+				Usage: "recompute header hashes, transaction hashes and merkle roots",
+			},
+		},
+		Action: verifyAction,
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return cli.NewExitError("", 1)
+		},
+	}
+}
+
+func verifyAction(c *cli.Context) error {
+	st, err := openStore(c.GlobalString("path"))
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	v := &verifier{st: st}
+	if err := v.verifyChain(c.Bool("deep")); err != nil {
+		return err
+	}
+
+	if c.Bool("deep") {
+		if err := v.verifyHeaderHashes(); err != nil {
+			return err
+		}
+		if err := v.verifyTransactionHashes(); err != nil {
+			return err
+		}
+	}
+
+	if v.issues > 0 {
+		return cli.NewExitError(fmt.Sprintf("verify failed with %d issues", v.issues), 1)
+	}
+	fmt.Println("verify ok")
+
+	return nil
+}
+
+func (v *verifier) report(format string, a ...interface{}) {
+	v.issues++
+	fmt.Printf(format+"\n", a...)
+}
+
+// verifyChain checks the main chain against DATA_BlockHash and that every
+// listed transaction is stored, with deep it also checks merkle roots.
+func (v *verifier) verifyChain(deep bool) error {
+	headers, err := loadHeaders(v.st, func(key []byte, err error) {
+		v.report("header %x: %v", key[1:], err)
+	})
+	if err != nil {
+		return err
+	}
+	pruned, err := getPrunedHeight(v.st)
+	if err != nil {
+		pruned = 0
+	}
+
+	chain, err := mainChain(v.st, headers)
+	if err != nil {
+		v.report("main chain: %v", err)
+		return nil
+	}
+
+	for height, hash := range chain {
+		if indexed, err := getBlockHash(v.st, uint32(height)); err != nil {
+			v.report("height %d: block hash index missing", height)
+		} else if indexed != hash {
+			v.report("height %d: block hash index %s, chain %s", height, hashString(indexed), hashString(hash))
+		}
+
+		data, err := v.st.Get(append([]byte{byte(db.DATA_Header)}, hash.ToArray()...))
+		if err != nil {
+			return err
+		}
+		b := new(ledger.Block)
+		if err := b.FromTrimmedData(bytes.NewReader(data[schema.headerPrefixSize:])); err != nil {
+			v.report("height %d: %v", height, err)
+			continue
+		}
+
+		// pruned blocks keep only their header
+		if height > 0 && uint32(height) < pruned {
+			continue
+		}
+		if len(b.Transactions) == 0 {
+			v.report("height %d: block has no transactions", height)
+			continue
+		}
+
+		hashes := make([]common.Uint256, 0, len(b.Transactions))
+		for _, txn := range b.Transactions {
+			txHash := txn.Hash()
+			hashes = append(hashes, txHash)
+			if _, err := v.st.Get(append([]byte{byte(db.DATA_Transaction)}, txHash.ToArray()...)); err != nil {
+				v.report("height %d: transaction %s missing", height, hashString(txHash))
+			}
+		}
+
+		if deep {
+			root, err := crypto.ComputeRoot(hashes)
+			if err != nil {
+				v.report("height %d: compute merkle root: %v", height, err)
+			} else if root != b.Header.TransactionsRoot {
+				v.report("height %d: merkle root %s, header has %s", height, hashString(root), hashString(b.Header.TransactionsRoot))
+			}
+		}
+	}
+	fmt.Printf("checked %d blocks\n", len(chain))
+
+	return nil
+}
+
+// verifyHeaderHashes checks that every DATA_Header key is the hash of its
+// header.
+func (v *verifier) verifyHeaderHashes() error {
+	count := 0
+	iter := v.st.NewIterator([]byte{byte(db.DATA_Header)})
+	defer iter.Release()
+	for iter.Next() {
+		count++
+		key := iter.Key()
+		h, err := parseHeader(iter.Value())
+		if err != nil {
+			v.report("header %x: %v", key[1:], err)
+			continue
+		}
+
+		hash := h.Hash()
+		if !bytes.Equal(hash.ToArray(), key[1:]) {
+			v.report("header %x: value hashes to %s", key[1:], hashString(hash))
+		}
+	}
+	fmt.Printf("checked %d header hashes\n", count)
+
+	return nil
+}
+
+// verifyTransactionHashes checks that every DATA_Transaction key is the hash
+// of its transaction.
+func (v *verifier) verifyTransactionHashes() error {
+	count := 0
+	iter := v.st.NewIterator([]byte{byte(db.DATA_Transaction)})
+	defer iter.Release()
+	for iter.Next() {
+		count++
+		key := iter.Key()
+		value := iter.Value()
+		if len(value) < 4 {
+			v.report("transaction %x: value too short", key[1:])
+			continue
+		}
+
+		txn := new(tx.Transaction)
+		if err := txn.Deserialize(bytes.NewReader(value[4:])); err != nil {
+			v.report("transaction %x: %v", key[1:], err)
+			continue
+		}
+
+		hash := txn.Hash()
+		if !bytes.Equal(hash.ToArray(), key[1:]) {
+			v.report("transaction %x: value hashes to %s", key[1:], hashString(hash))
+		}
+	}
+	fmt.Printf("checked %d transaction hashes\n", count)
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nknorg/nkn/db"
+)
+
+func TestVerifyReportsBadHeaders(t *testing.T) {
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(1, 100)))
+	c.addBlock(t, testTransfer(nil, testOutput(2, 100)))
+	c.st.put(append([]byte{byte(db.DATA_Header)}, make([]byte, 32)...), []byte{0x01})
+
+	v := &verifier{st: c.st}
+	if err := v.verifyChain(false); err != nil {
+		t.Fatal(err)
+	}
+	if v.issues != 1 {
+		t.Fatalf("%d issues, want 1 for the bad header", v.issues)
+	}
+
+	// --deep reads every header again
+	v = &verifier{st: c.st}
+	if err := v.verifyHeaderHashes(); err != nil {
+		t.Fatal(err)
+	}
+	if v.issues != 1 {
+		t.Fatalf("%d issues in header hashes, want 1 for the bad header", v.issues)
+	}
+}
+
+func TestVerifySkipsPrunedBlocks(t *testing.T) {
+	c := newTestChain()
+	c.addBlock(t, testTransfer(nil, testOutput(1, 100)))
+	spent := testTransfer(nil, testOutput(2, 100))
+	c.addBlock(t, spent)
+	c.addBlock(t, testTransfer(nil, testOutput(3, 100)))
+
+	if err := c.st.NewBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneBlock(c.st, 1, nil, &pruneResult{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.st.BatchPut(prunedHeightKey, heightBytes(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.st.BatchCommit(); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &verifier{st: c.st}
+	if err := v.verifyChain(false); err != nil {
+		t.Fatal(err)
+	}
+	if v.issues != 0 {
+		t.Fatalf("%d issues on a pruned db, want 0", v.issues)
+	}
+}